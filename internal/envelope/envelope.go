@@ -0,0 +1,123 @@
+package envelope
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	// HeaderKind is the Kafka record header describing the payload of the message.
+	HeaderKind = "mimic-kind"
+	// HeaderTenant is the Kafka record header with the tenant ID of the message, absent without a tenant.
+	HeaderTenant = "mimic-tenant"
+	// HeaderUser is the Kafka record header with the login of the user who sent the message.
+	HeaderUser = "mimic-user"
+	// HeaderProtocol is the Kafka record header with the write protocol the message was received with.
+	HeaderProtocol = "mimic-protocol"
+	// HeaderReceivedAt is the Kafka record header with the gateway receive time in Unix milliseconds.
+	HeaderReceivedAt = "mimic-received-at"
+	// HeaderSchemaVersion is the Kafka record header with the envelope schema version.
+	HeaderSchemaVersion = "mimic-schema-version"
+
+	// SchemaVersion is the envelope schema version produced by the gateway. Messages without
+	// the header were produced before headers were introduced and have version 0.
+	SchemaVersion = 1
+
+	// KindTimeSeries is a single prompb.TimeSeries, also assumed when the header is absent.
+	KindTimeSeries = "timeseries"
+	// KindMetadata is a single prompb.MetricMetadata keyed by the metric family name.
+	KindMetadata = "metadata"
+	// KindWriteRequest is a prompb.WriteRequest with series sharing the partition key.
+	KindWriteRequest = "writerequest"
+)
+
+// Envelope is the metadata of the message carried in Kafka record headers.
+type Envelope struct {
+	Kind          string
+	Tenant        string
+	User          string
+	Protocol      string
+	ReceivedAt    time.Time
+	SchemaVersion int
+}
+
+// Headers returns Kafka record headers of the envelope, empty fields are omitted.
+func (e Envelope) Headers() []sarama.RecordHeader {
+	headers := make([]sarama.RecordHeader, 0, 6)
+
+	add := func(key, value string) {
+		if value != "" {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+		}
+	}
+
+	add(HeaderSchemaVersion, strconv.Itoa(e.SchemaVersion))
+	add(HeaderKind, e.Kind)
+	add(HeaderTenant, e.Tenant)
+	add(HeaderUser, e.User)
+	add(HeaderProtocol, e.Protocol)
+
+	if !e.ReceivedAt.IsZero() {
+		add(HeaderReceivedAt, strconv.FormatInt(e.ReceivedAt.UnixMilli(), 10))
+	}
+
+	return headers
+}
+
+// Parse returns the envelope of the consumed message.
+func Parse(headers []*sarama.RecordHeader) (Envelope, error) {
+	envelope := Envelope{
+		Kind:     Kind(headers),
+		Tenant:   Header(headers, HeaderTenant),
+		User:     Header(headers, HeaderUser),
+		Protocol: Header(headers, HeaderProtocol),
+	}
+
+	if value := Header(headers, HeaderSchemaVersion); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			return envelope, fmt.Errorf("malformed %s header: %w", HeaderSchemaVersion, err)
+		}
+
+		envelope.SchemaVersion = version
+	}
+
+	if value := Header(headers, HeaderReceivedAt); value != "" {
+		receivedAt, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return envelope, fmt.Errorf("malformed %s header: %w", HeaderReceivedAt, err)
+		}
+
+		envelope.ReceivedAt = time.UnixMilli(receivedAt)
+	}
+
+	return envelope, nil
+}
+
+// Kind returns the payload kind of the consumed message.
+func Kind(headers []*sarama.RecordHeader) string {
+	if kind := Header(headers, HeaderKind); kind != "" {
+		return kind
+	}
+
+	return KindTimeSeries
+}
+
+// Tenant returns the tenant ID of the consumed message or an empty string.
+func Tenant(headers []*sarama.RecordHeader) string {
+	return Header(headers, HeaderTenant)
+}
+
+// Header returns the value of the Kafka record header or an empty string when it is absent.
+func Header(headers []*sarama.RecordHeader, key string) string {
+	for _, header := range headers {
+		if header != nil && string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+
+	return ""
+}
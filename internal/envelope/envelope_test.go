@@ -0,0 +1,86 @@
+package envelope
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKind(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []*sarama.RecordHeader
+		want    string
+	}{
+		{
+			name:    "no headers",
+			headers: nil,
+			want:    KindTimeSeries,
+		},
+		{
+			name: "metadata",
+			headers: []*sarama.RecordHeader{
+				{Key: []byte("other"), Value: []byte("value")},
+				{Key: []byte(HeaderKind), Value: []byte(KindMetadata)},
+			},
+			want: KindMetadata,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Kind(tt.headers))
+		})
+	}
+}
+
+func TestTenant(t *testing.T) {
+	assert.Equal(t, "", Tenant(nil))
+	assert.Equal(t, "42", Tenant([]*sarama.RecordHeader{
+		nil,
+		{Key: []byte(HeaderTenant), Value: []byte("42")},
+	}))
+}
+
+func TestEnvelope(t *testing.T) {
+	t.Run("headers round trip", func(t *testing.T) {
+		envelope := Envelope{
+			Kind:          KindMetadata,
+			Tenant:        "42",
+			User:          "team-a",
+			Protocol:      "prometheus_v2",
+			ReceivedAt:    time.UnixMilli(1700000000123),
+			SchemaVersion: SchemaVersion,
+		}
+
+		headers := envelope.Headers()
+
+		consumed := make([]*sarama.RecordHeader, 0, len(headers))
+		for idx := range headers {
+			consumed = append(consumed, &headers[idx])
+		}
+
+		got, err := Parse(consumed)
+		require.NoError(t, err)
+		assert.Equal(t, envelope, got)
+	})
+
+	t.Run("empty fields are omitted", func(t *testing.T) {
+		headers := Envelope{Kind: KindTimeSeries, SchemaVersion: SchemaVersion}.Headers()
+		assert.Len(t, headers, 2)
+	})
+
+	t.Run("message without headers", func(t *testing.T) {
+		got, err := Parse(nil)
+		require.NoError(t, err)
+		assert.Equal(t, Envelope{Kind: KindTimeSeries}, got)
+	})
+
+	t.Run("malformed receive time", func(t *testing.T) {
+		_, err := Parse([]*sarama.RecordHeader{{Key: []byte(HeaderReceivedAt), Value: []byte("yesterday")}})
+		assert.Error(t, err)
+	})
+}
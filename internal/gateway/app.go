@@ -1,8 +1,8 @@
 package gateway
 
 import (
+	"fmt"
 	"log"
-	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/gin-gonic/gin"
@@ -16,8 +16,8 @@ type Gateway struct {
 	config        *Config
 	kafkaClient   sarama.Client
 	kafkaProducer sarama.AsyncProducer
-
-	lastErrorTime time.Time
+	spool         *spool
+	breakers      *circuitBreakers
 }
 
 func New(configPath string) (*Gateway, error) {
@@ -35,21 +35,33 @@ func New(configPath string) (*Gateway, error) {
 		config:        config,
 		kafkaClient:   kafkaClient,
 		kafkaProducer: kafkaProducer,
+		breakers:      newCircuitBreakers(config.Kafka.CircuitBreaker),
+	}
+
+	if config.Spool.Path != "" {
+		gateway.spool, err = newSpool(config.Spool)
+		if err != nil {
+			log.Fatalf("failed to open spool: %v", err)
+		}
+
+		go gateway.replaySpool()
 	}
 
 	go gateway.monitorKafkaHealth()
+	go gateway.monitorKafkaSuccesses()
 
 	return gateway, nil
 }
 
 func newKafka(config *Config) (sarama.AsyncProducer, sarama.Client, error) {
-	kafkaConf := sarama.NewConfig()
-	kafkaConf.ClientID = "mimic-gateway"
-	kafkaConf.Version = sarama.V2_8_0_0
-	kafkaConf.Producer.RequiredAcks = sarama.WaitForLocal
-	kafkaConf.Producer.Flush.Frequency = 10 * time.Second
-	kafkaConf.Producer.Flush.Bytes = maxInsertRequestSize / 4
-	kafkaConf.Producer.Flush.Messages = 100_000
+	kafkaConf, err := newSaramaConfig(config.Kafka)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// successes feed the circuit breakers, the synchronous write mode and the spool replay
+	kafkaConf.Producer.Return.Successes = true
+	kafkaConf.Producer.Partitioner = newKeyPartitioner
 
 	client, err := sarama.NewClient(config.Kafka.Brokers, kafkaConf)
 	if err != nil {
@@ -64,10 +76,106 @@ func newKafka(config *Config) (sarama.AsyncProducer, sarama.Client, error) {
 	return producer, client, nil
 }
 
+// newSaramaConfig returns the producer config of the gateway validated by sarama.
+func newSaramaConfig(config KafkaConfig) (*sarama.Config, error) {
+	kafkaConf := sarama.NewConfig()
+	kafkaConf.ClientID = config.ClientID
+
+	version, err := sarama.ParseKafkaVersion(config.Version)
+	if err != nil {
+		return nil, err
+	}
+	kafkaConf.Version = version
+
+	if config.DialTimeout > 0 {
+		kafkaConf.Net.DialTimeout = config.DialTimeout
+	}
+
+	if config.ReadTimeout > 0 {
+		kafkaConf.Net.ReadTimeout = config.ReadTimeout
+	}
+
+	if config.WriteTimeout > 0 {
+		kafkaConf.Net.WriteTimeout = config.WriteTimeout
+	}
+
+	switch config.Producer.RequiredAcks {
+	case "none":
+		kafkaConf.Producer.RequiredAcks = sarama.NoResponse
+	case "local":
+		kafkaConf.Producer.RequiredAcks = sarama.WaitForLocal
+	case "all":
+		kafkaConf.Producer.RequiredAcks = sarama.WaitForAll
+	default:
+		return nil, fmt.Errorf("unknown required_acks: %s", config.Producer.RequiredAcks)
+	}
+
+	if err := kafkaConf.Producer.Compression.UnmarshalText([]byte(config.Producer.Compression)); err != nil {
+		return nil, err
+	}
+
+	if config.Producer.Idempotent {
+		kafkaConf.Producer.Idempotent = true
+		// sarama supports the idempotent producer only with a single in-flight request per broker
+		kafkaConf.Net.MaxOpenRequests = 1
+	}
+
+	kafkaConf.Producer.Flush.Frequency = config.Producer.FlushFrequency
+	kafkaConf.Producer.Flush.Bytes = config.Producer.FlushBytes
+	kafkaConf.Producer.Flush.Messages = config.Producer.FlushMessages
+
+	if config.Producer.MaxMessageBytes > 0 {
+		kafkaConf.Producer.MaxMessageBytes = config.Producer.MaxMessageBytes
+	}
+
+	if config.Producer.RetryMax != nil {
+		kafkaConf.Producer.Retry.Max = *config.Producer.RetryMax
+	}
+
+	if config.Producer.RetryBackoff > 0 {
+		kafkaConf.Producer.Retry.Backoff = config.Producer.RetryBackoff
+	}
+
+	if config.Producer.Timeout > 0 {
+		kafkaConf.Producer.Timeout = config.Producer.Timeout
+	}
+
+	if err := config.Net.Apply(kafkaConf); err != nil {
+		return nil, err
+	}
+
+	if err := kafkaConf.Validate(); err != nil {
+		return nil, err
+	}
+
+	return kafkaConf, nil
+}
+
 func (g *Gateway) monitorKafkaHealth() {
 	for err := range g.kafkaProducer.Errors() {
 		log.Printf("failed to write entry: %s", err.Error())
 
-		g.lastErrorTime = time.Now()
+		g.breakers.record(err.Msg.Topic, false)
+
+		if ack, ok := err.Msg.Metadata.(*produceAck); ok {
+			ack.done(err.Msg, err.Err)
+			continue
+		}
+
+		if g.spool != nil {
+			if err := g.spool.write([]*sarama.ProducerMessage{err.Msg}); err != nil {
+				log.Printf("failed to spool entry: %v", err)
+			}
+		}
+	}
+}
+
+func (g *Gateway) monitorKafkaSuccesses() {
+	for msg := range g.kafkaProducer.Successes() {
+		g.breakers.record(msg.Topic, true)
+
+		if ack, ok := msg.Metadata.(*produceAck); ok {
+			ack.done(msg, nil)
+		}
 	}
 }
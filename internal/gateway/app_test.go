@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestGateway returns gateway backed by a mock broker for metadata and a mock async producer.
+func newTestGateway(t *testing.T, config *Config) (*Gateway, *mocks.AsyncProducer) {
+	t.Helper()
+
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(broker.Addr(), broker.BrokerID())
+
+	for _, topic := range (&Gateway{config: config}).topics() {
+		metadata.SetLeader(topic, 0, broker.BrokerID())
+	}
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata,
+	})
+
+	kafkaConf := mocks.NewTestConfig()
+	kafkaConf.Producer.Return.Successes = true
+
+	client, err := sarama.NewClient([]string{broker.Addr()}, kafkaConf)
+	if err != nil {
+		t.Fatalf("failed to create kafka client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	producer := mocks.NewAsyncProducer(t, kafkaConf)
+
+	config.Kafka.CircuitBreaker.setDefaults()
+
+	gateway := &Gateway{
+		config:        config,
+		kafkaClient:   client,
+		kafkaProducer: producer,
+		breakers:      newCircuitBreakers(config.Kafka.CircuitBreaker),
+	}
+
+	if config.Spool.Path != "" {
+		config.Spool.setDefaults()
+
+		gateway.spool, err = newSpool(config.Spool)
+		if err != nil {
+			t.Fatalf("failed to open spool: %v", err)
+		}
+		t.Cleanup(func() { gateway.spool.Close() })
+	}
+
+	go gateway.monitorKafkaHealth()
+	go gateway.monitorKafkaSuccesses()
+
+	return gateway, producer
+}
+
+// serveTestRoute serves the request by a router with only the route of the request method and the handlers.
+func serveTestRoute(route string, req *http.Request, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(req.Method, route, handlers...)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestNewSaramaConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		config := loadTestConfig(t, "kafka:\n  topic: metrics\n")
+
+		kafkaConf, err := newSaramaConfig(config.Kafka)
+		require.NoError(t, err)
+
+		assert.Equal(t, "mimic-gateway", kafkaConf.ClientID)
+		assert.Equal(t, sarama.V2_8_0_0, kafkaConf.Version)
+		assert.Equal(t, sarama.WaitForLocal, kafkaConf.Producer.RequiredAcks)
+		assert.Equal(t, sarama.CompressionNone, kafkaConf.Producer.Compression)
+		assert.Equal(t, 10*time.Second, kafkaConf.Producer.Flush.Frequency)
+		assert.Equal(t, 100_000, kafkaConf.Producer.Flush.Messages)
+	})
+
+	t.Run("tuned", func(t *testing.T) {
+		config := loadTestConfig(t, `
+kafka:
+  topic: metrics
+  client_id: gateway-1
+  version: 3.6.0
+  dial_timeout: 5s
+  producer:
+    required_acks: all
+    idempotent: true
+    compression: zstd
+    flush_frequency: 100ms
+    flush_bytes: 1048576
+    flush_messages: 1000
+    max_message_bytes: 2097152
+    retry_max: 10
+    retry_backoff: 250ms
+    timeout: 30s
+`)
+
+		kafkaConf, err := newSaramaConfig(config.Kafka)
+		require.NoError(t, err)
+
+		assert.Equal(t, "gateway-1", kafkaConf.ClientID)
+		assert.Equal(t, sarama.V3_6_0_0, kafkaConf.Version)
+		assert.Equal(t, 5*time.Second, kafkaConf.Net.DialTimeout)
+		assert.Equal(t, sarama.WaitForAll, kafkaConf.Producer.RequiredAcks)
+		assert.True(t, kafkaConf.Producer.Idempotent)
+		assert.Equal(t, 1, kafkaConf.Net.MaxOpenRequests)
+		assert.Equal(t, sarama.CompressionZSTD, kafkaConf.Producer.Compression)
+		assert.Equal(t, 100*time.Millisecond, kafkaConf.Producer.Flush.Frequency)
+		assert.Equal(t, 1048576, kafkaConf.Producer.Flush.Bytes)
+		assert.Equal(t, 1000, kafkaConf.Producer.Flush.Messages)
+		assert.Equal(t, 2097152, kafkaConf.Producer.MaxMessageBytes)
+		assert.Equal(t, 10, kafkaConf.Producer.Retry.Max)
+		assert.Equal(t, 250*time.Millisecond, kafkaConf.Producer.Retry.Backoff)
+		assert.Equal(t, 30*time.Second, kafkaConf.Producer.Timeout)
+	})
+
+	t.Run("retries can be disabled", func(t *testing.T) {
+		config := loadTestConfig(t, "kafka:\n  producer:\n    retry_max: 0\n")
+
+		kafkaConf, err := newSaramaConfig(config.Kafka)
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, kafkaConf.Producer.Retry.Max)
+	})
+}
+
+func TestKafkaConfigValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		kafka string
+	}{
+		{name: "unknown version", kafka: "version: 0.1.2"},
+		{name: "unknown required acks", kafka: "producer:\n    required_acks: some"},
+		{name: "unknown compression", kafka: "producer:\n    compression: brotli"},
+		{name: "idempotent without all acks", kafka: "producer:\n    idempotent: true"},
+		{name: "packing above max message bytes", kafka: "packing:\n    enabled: true\n    max_bytes: 2000000"},
+		{name: "circuit breaker error rate above one", kafka: "circuit_breaker:\n    error_rate: 1.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte("kafka:\n  "+tt.kafka+"\n"), 0o600))
+
+			_, err := loadConfig(path)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewSaramaConfigNet(t *testing.T) {
+	config := loadTestConfig(t, `
+kafka:
+  topic: metrics
+  tls:
+    insecure_skip_verify: true
+  sasl:
+    mechanism: SCRAM-SHA-512
+    username: gateway
+    password: secret
+`)
+
+	kafkaConf, err := newSaramaConfig(config.Kafka)
+	require.NoError(t, err)
+
+	assert.True(t, kafkaConf.Net.TLS.Enable)
+	assert.True(t, kafkaConf.Net.SASL.Enable)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), kafkaConf.Net.SASL.Mechanism)
+	assert.Equal(t, "gateway", kafkaConf.Net.SASL.User)
+	assert.Equal(t, "secret", kafkaConf.Net.SASL.Password)
+}
@@ -0,0 +1,262 @@
+package gateway
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// CircuitBreakerConfig configures per-topic circuit breakers of the producer.
+type CircuitBreakerConfig struct {
+	// Window is the period producer results are counted over while the breaker is closed.
+	Window time.Duration `yaml:"window"`
+	// MinMessages is the number of results in the window required to evaluate the error rate.
+	MinMessages int `yaml:"min_messages"`
+	// ErrorRate opens the breaker when the share of failed messages in the window reaches it.
+	ErrorRate float64 `yaml:"error_rate"`
+	// OpenDuration is the time the breaker rejects writes before it lets them through again.
+	OpenDuration time.Duration `yaml:"open_duration"`
+	// HalfOpenSuccesses is the number of delivered messages that closes the half-open breaker.
+	HalfOpenSuccesses int `yaml:"half_open_successes"`
+}
+
+func (c *CircuitBreakerConfig) setDefaults() {
+	if c.Window == 0 {
+		c.Window = 10 * time.Second
+	}
+
+	if c.MinMessages == 0 {
+		c.MinMessages = 20
+	}
+
+	if c.ErrorRate == 0 {
+		c.ErrorRate = 0.5
+	}
+
+	if c.OpenDuration == 0 {
+		c.OpenDuration = 10 * time.Second
+	}
+
+	if c.HalfOpenSuccesses == 0 {
+		c.HalfOpenSuccesses = 10
+	}
+}
+
+func (c *CircuitBreakerConfig) validate() error {
+	if c.ErrorRate <= 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("circuit breaker error_rate must be in (0, 1]: %v", c.ErrorRate)
+	}
+
+	if c.Window < 0 || c.OpenDuration < 0 || c.MinMessages < 0 || c.HalfOpenSuccesses < 0 {
+		return fmt.Errorf("circuit breaker settings must not be negative")
+	}
+
+	return nil
+}
+
+// circuitOpenError rejects writes to the topic while its breaker is open.
+type circuitOpenError struct {
+	topic      string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("kafka topic %s is unavailable, retry after %s", e.topic, e.retryAfter)
+}
+
+// retryAfterSeconds returns the value of the Retry-After header.
+func (e *circuitOpenError) retryAfterSeconds() int {
+	return max(int(math.Ceil(e.retryAfter.Seconds())), 1)
+}
+
+// circuitBreaker tracks producer results of a topic. The closed breaker opens when the error rate
+// in the window reaches the threshold, the open one rejects writes for the open duration and then
+// becomes half-open, which lets writes through and closes after enough deliveries or opens again
+// on the first failure.
+type circuitBreaker struct {
+	topic  string
+	config CircuitBreakerConfig
+
+	mu          sync.Mutex
+	state       string
+	windowStart time.Time
+	successes   int
+	failures    int
+	openedAt    time.Time
+}
+
+func newCircuitBreaker(topic string, config CircuitBreakerConfig) *circuitBreaker {
+	metricCircuitBreakerState.WithLabelValues(topic).Set(0)
+
+	return &circuitBreaker{
+		topic:       topic,
+		config:      config,
+		state:       breakerClosed,
+		windowStart: time.Now(),
+	}
+}
+
+// allow returns the remaining open time when the breaker rejects writes.
+func (b *circuitBreaker) allow(now time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerOpen {
+		return 0, true
+	}
+
+	if remaining := b.config.OpenDuration - now.Sub(b.openedAt); remaining > 0 {
+		return remaining, false
+	}
+
+	b.transition(breakerHalfOpen, now)
+
+	return 0, true
+}
+
+func (b *circuitBreaker) record(success bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		if now.Sub(b.windowStart) > b.config.Window {
+			b.windowStart = now
+			b.successes = 0
+			b.failures = 0
+		}
+
+		if success {
+			b.successes++
+			return
+		}
+
+		b.failures++
+
+		total := b.successes + b.failures
+		if total >= b.config.MinMessages && float64(b.failures)/float64(total) >= b.config.ErrorRate {
+			b.transition(breakerOpen, now)
+		}
+
+	case breakerHalfOpen:
+		if !success {
+			b.transition(breakerOpen, now)
+			return
+		}
+
+		b.successes++
+		if b.successes >= b.config.HalfOpenSuccesses {
+			b.transition(breakerClosed, now)
+		}
+
+	case breakerOpen:
+		// results of messages produced before the breaker opened
+	}
+}
+
+// transition must be called with the lock held.
+func (b *circuitBreaker) transition(state string, now time.Time) {
+	log.Printf("circuit breaker of kafka topic %s: %s -> %s", b.topic, b.state, state)
+
+	metricCircuitBreakerTransitions.WithLabelValues(b.topic, b.state, state).Inc()
+
+	b.state = state
+	b.windowStart = now
+	b.successes = 0
+	b.failures = 0
+
+	switch state {
+	case breakerClosed:
+		metricCircuitBreakerState.WithLabelValues(b.topic).Set(0)
+	case breakerOpen:
+		b.openedAt = now
+		metricCircuitBreakerState.WithLabelValues(b.topic).Set(1)
+	case breakerHalfOpen:
+		metricCircuitBreakerState.WithLabelValues(b.topic).Set(2)
+	}
+}
+
+// circuitBreakers holds the breaker of every topic the gateway produces to.
+type circuitBreakers struct {
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(config CircuitBreakerConfig) *circuitBreakers {
+	return &circuitBreakers{
+		config:   config,
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+func (b *circuitBreakers) get(topic string) *circuitBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker, ok := b.breakers[topic]
+	if !ok {
+		breaker = newCircuitBreaker(topic, b.config)
+		b.breakers[topic] = breaker
+	}
+
+	return breaker
+}
+
+// check returns the error of the first topic of the messages with the open breaker.
+func (b *circuitBreakers) check(messages []*sarama.ProducerMessage) *circuitOpenError {
+	now := time.Now()
+	checked := make(map[string]struct{})
+
+	for _, message := range messages {
+		if _, ok := checked[message.Topic]; ok {
+			continue
+		}
+
+		checked[message.Topic] = struct{}{}
+
+		if retryAfter, ok := b.get(message.Topic).allow(now); !ok {
+			return &circuitOpenError{topic: message.Topic, retryAfter: retryAfter}
+		}
+	}
+
+	return nil
+}
+
+func (b *circuitBreakers) record(topic string, success bool) {
+	b.get(topic).record(success, time.Now())
+}
+
+// open returns the sorted topics whose breakers still reject writes.
+func (b *circuitBreakers) open() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+
+	var topics []string
+
+	for topic, breaker := range b.breakers {
+		breaker.mu.Lock()
+		if breaker.state == breakerOpen && now.Sub(breaker.openedAt) < breaker.config.OpenDuration {
+			topics = append(topics, topic)
+		}
+		breaker.mu.Unlock()
+	}
+
+	slices.Sort(topics)
+
+	return topics
+}
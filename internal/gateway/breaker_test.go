@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setTestBreakerState forces the breaker into the state as if it has just transitioned to it.
+func setTestBreakerState(b *circuitBreaker, state string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.transition(state, time.Now())
+}
+
+func getTestBreakerState(b *circuitBreaker) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	config := CircuitBreakerConfig{
+		Window:            10 * time.Second,
+		MinMessages:       4,
+		ErrorRate:         0.5,
+		OpenDuration:      5 * time.Second,
+		HalfOpenSuccesses: 2,
+	}
+
+	t.Run("opens on error rate", func(t *testing.T) {
+		b := newCircuitBreaker("breaker-rate", config)
+		now := time.Now()
+
+		b.record(true, now)
+		b.record(true, now)
+		b.record(false, now)
+		assert.Equal(t, breakerClosed, getTestBreakerState(b), "below min messages")
+
+		b.record(false, now)
+		assert.Equal(t, breakerOpen, getTestBreakerState(b))
+
+		retryAfter, ok := b.allow(now.Add(time.Second))
+		assert.False(t, ok)
+		assert.Equal(t, 4*time.Second, retryAfter)
+	})
+
+	t.Run("window resets counters", func(t *testing.T) {
+		b := newCircuitBreaker("breaker-window", config)
+		now := time.Now()
+
+		b.record(false, now)
+		b.record(false, now)
+		b.record(false, now)
+
+		later := now.Add(11 * time.Second)
+		b.record(false, later)
+		b.record(true, later)
+		b.record(true, later)
+		b.record(true, later)
+		assert.Equal(t, breakerClosed, getTestBreakerState(b))
+	})
+
+	t.Run("half-open closes after successes", func(t *testing.T) {
+		b := newCircuitBreaker("breaker-close", config)
+		setTestBreakerState(b, breakerOpen)
+
+		_, ok := b.allow(time.Now().Add(config.OpenDuration))
+		require.True(t, ok)
+		assert.Equal(t, breakerHalfOpen, getTestBreakerState(b))
+
+		b.record(true, time.Now())
+		assert.Equal(t, breakerHalfOpen, getTestBreakerState(b))
+
+		b.record(true, time.Now())
+		assert.Equal(t, breakerClosed, getTestBreakerState(b))
+	})
+
+	t.Run("half-open opens on failure", func(t *testing.T) {
+		b := newCircuitBreaker("breaker-reopen", config)
+		setTestBreakerState(b, breakerHalfOpen)
+
+		b.record(false, time.Now())
+		assert.Equal(t, breakerOpen, getTestBreakerState(b))
+	})
+}
+
+func TestCircuitBreakersScope(t *testing.T) {
+	breakers := newCircuitBreakers(CircuitBreakerConfig{OpenDuration: time.Minute})
+	setTestBreakerState(breakers.get("scope-broken"), breakerOpen)
+
+	assert.Nil(t, breakers.check([]*sarama.ProducerMessage{{Topic: "scope-healthy"}}))
+
+	openErr := breakers.check([]*sarama.ProducerMessage{{Topic: "scope-healthy"}, {Topic: "scope-broken"}})
+	require.NotNil(t, openErr)
+	assert.Equal(t, "scope-broken", openErr.topic)
+	assert.Equal(t, 60, openErr.retryAfterSeconds())
+}
+
+func TestWriteHandlerCircuitOpen(t *testing.T) {
+	g, _ := newTestGateway(t, &Config{
+		Kafka: KafkaConfig{Topic: "metrics-breaker"},
+	})
+
+	setTestBreakerState(g.breakers.get("metrics-breaker"), breakerOpen)
+
+	w := serveTestWrite(g, newTestWriteRequest(t, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			},
+		},
+	}))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+}
+
+func TestCircuitBreakerProducerErrors(t *testing.T) {
+	g, producer := newTestGateway(t, &Config{
+		Kafka: KafkaConfig{
+			Topic:          "metrics-errors",
+			SyncAck:        true,
+			CircuitBreaker: CircuitBreakerConfig{MinMessages: 1},
+		},
+	})
+
+	producer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+
+	req := newTestWriteRequest(t, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			},
+		},
+	})
+
+	w := serveTestWrite(g, req)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	assert.Equal(t, breakerOpen, getTestBreakerState(g.breakers.get("metrics-errors")))
+}
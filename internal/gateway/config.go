@@ -4,24 +4,202 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/IBM/sarama"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
 	"gopkg.in/yaml.v3"
+
+	"github.com/vitalvas/prometheus-mimic/internal/kafkanet"
 )
 
 type Config struct {
 	Kafka KafkaConfig `yaml:"kafka"`
 	Users []User      `yaml:"users"`
+	Spool SpoolConfig `yaml:"spool"`
+	OTLP  OTLPConfig  `yaml:"otlp"`
+
+	// Routes select the series topic by metric name and labels, evaluated in order.
+	Routes []*Route `yaml:"routes"`
+
+	WriteRelabelConfigs []*relabel.Config `yaml:"write_relabel_configs"`
+
+	// Graphite listeners receive the Graphite plaintext protocol over TCP and UDP.
+	Graphite []*GraphiteConfig `yaml:"graphite"`
+
+	// StatsD listeners aggregate StatsD and DogStatsD metrics received over UDP.
+	StatsD []*StatsDConfig `yaml:"statsd"`
 }
 
 type KafkaConfig struct {
 	Topic   string   `yaml:"topic"`
 	Brokers []string `yaml:"brokers"`
+
+	ClientID string `yaml:"client_id"`
+	Version  string `yaml:"version"`
+
+	DialTimeout  time.Duration `yaml:"dial_timeout"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// Net configures TLS and SASL of connections to brokers.
+	Net kafkanet.Config `yaml:",inline"`
+
+	Producer ProducerConfig `yaml:"producer"`
+
+	// MetadataTopic receives metric metadata instead of the series topic when set.
+	MetadataTopic string `yaml:"metadata_topic"`
+
+	// SyncAck makes write requests wait until every message is acknowledged by Kafka.
+	SyncAck bool `yaml:"sync_ack"`
+
+	Packing PackingConfig `yaml:"packing"`
+
+	// PartitionKey is the partition key strategy of series, routes may override it.
+	PartitionKey PartitionKeyConfig `yaml:"partition_key"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// ProducerConfig tunes the Kafka producer, zero values keep the defaults of the gateway or sarama.
+type ProducerConfig struct {
+	// RequiredAcks is one of none, local or all.
+	RequiredAcks string `yaml:"required_acks"`
+	Idempotent   bool   `yaml:"idempotent"`
+	// Compression is one of none, gzip, snappy, lz4 or zstd.
+	Compression string `yaml:"compression"`
+
+	FlushFrequency time.Duration `yaml:"flush_frequency"`
+	FlushBytes     int           `yaml:"flush_bytes"`
+	FlushMessages  int           `yaml:"flush_messages"`
+
+	MaxMessageBytes int `yaml:"max_message_bytes"`
+
+	RetryMax     *int          `yaml:"retry_max"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// Timeout is the time the broker waits for the required acks.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *KafkaConfig) setDefaults() {
+	if c.ClientID == "" {
+		c.ClientID = "mimic-gateway"
+	}
+
+	if c.Version == "" {
+		c.Version = sarama.V2_8_0_0.String()
+	}
+
+	if c.Producer.RequiredAcks == "" {
+		c.Producer.RequiredAcks = "local"
+	}
+
+	if c.Producer.Compression == "" {
+		c.Producer.Compression = "none"
+	}
+
+	if c.Producer.FlushFrequency == 0 {
+		c.Producer.FlushFrequency = 10 * time.Second
+	}
+
+	if c.Producer.FlushBytes == 0 {
+		c.Producer.FlushBytes = maxInsertRequestSize / 4
+	}
+
+	if c.Producer.FlushMessages == 0 {
+		c.Producer.FlushMessages = 100_000
+	}
+
+	c.Packing.setDefaults()
+	c.CircuitBreaker.setDefaults()
+}
+
+// PackingConfig configures producing series sharing the partition key as a single message.
+// Every series is produced as a separate message when it is disabled.
+type PackingConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	MaxBytes  int  `yaml:"max_bytes"`
+	MaxSeries int  `yaml:"max_series"`
+}
+
+func (c *PackingConfig) setDefaults() {
+	if c.MaxBytes == 0 {
+		c.MaxBytes = 512 * 1024 // 512KB, below the default Kafka message size limit
+	}
+
+	if c.MaxSeries == 0 {
+		c.MaxSeries = 1000
+	}
+}
+
+// SpoolConfig configures the on-disk spool used while Kafka is unavailable. It is disabled without the path.
+type SpoolConfig struct {
+	Path           string        `yaml:"path"`
+	MaxSize        int64         `yaml:"max_size"`
+	MaxSegmentSize int64         `yaml:"max_segment_size"`
+	MaxAge         time.Duration `yaml:"max_age"`
+	ReplayInterval time.Duration `yaml:"replay_interval"`
+}
+
+func (c *SpoolConfig) setDefaults() {
+	if c.MaxSize == 0 {
+		c.MaxSize = 1024 * 1024 * 1024 // 1GB
+	}
+
+	if c.MaxSegmentSize == 0 {
+		c.MaxSegmentSize = 64 * 1024 * 1024 // 64MB
+	}
+
+	if c.MaxAge == 0 {
+		c.MaxAge = 24 * time.Hour
+	}
+
+	if c.ReplayInterval == 0 {
+		c.ReplayInterval = 10 * time.Second
+	}
+}
+
+// OTLPConfig configures translation of OpenTelemetry metrics to Prometheus series.
+type OTLPConfig struct {
+	// PromoteResourceAttributes are added as labels to every series of the resource.
+	PromoteResourceAttributes []string `yaml:"promote_resource_attributes"`
+	// KeepIdentifyingResourceAttributes keeps service.name, service.namespace and service.instance.id
+	// on target_info in addition to the job and instance labels.
+	KeepIdentifyingResourceAttributes bool `yaml:"keep_identifying_resource_attributes"`
+	// ConvertHistogramsToNHCB converts explicit bucket histograms to native histograms with custom buckets.
+	ConvertHistogramsToNHCB bool `yaml:"convert_histograms_to_nhcb"`
+	// AllowDeltaTemporality writes delta sums and histograms as received instead of rejecting them.
+	AllowDeltaTemporality bool `yaml:"allow_delta_temporality"`
 }
 
 type User struct {
 	Login    string  `yaml:"login"`
 	Password string  `yaml:"password"`
 	Topic    *string `yaml:"topic"`
+
+	// Tenant is carried with the messages of the user, so the worker can write them to the tenant endpoint.
+	Tenant string `yaml:"tenant"`
+	// TenantFromHeader allows the user to set the tenant with the X-Scope-OrgID header.
+	TenantFromHeader bool `yaml:"tenant_from_header"`
+	// ExternalLabels are set on every series of the user, overwriting the labels sent by the client.
+	ExternalLabels map[string]string `yaml:"external_labels"`
+
+	WriteRelabelConfigs []*relabel.Config `yaml:"write_relabel_configs"`
+}
+
+func (u *User) validate() error {
+	if err := validateTenant(u.Tenant); err != nil {
+		return err
+	}
+
+	for name := range u.ExternalLabels {
+		if !model.LabelName(name).IsValid() || name == model.MetricNameLabel {
+			return fmt.Errorf("invalid external label name: %s", name)
+		}
+	}
+
+	return nil
 }
 
 func loadConfig(filename string) (*Config, error) {
@@ -39,5 +217,78 @@ func loadConfig(filename string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal yaml config: %w", err)
 	}
 
+	config.Spool.setDefaults()
+	config.Kafka.setDefaults()
+
+	kafkaConf, err := newSaramaConfig(config.Kafka)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka config: %w", err)
+	}
+
+	if config.Kafka.Packing.Enabled && config.Kafka.Packing.MaxBytes > kafkaConf.Producer.MaxMessageBytes {
+		return nil, fmt.Errorf("packing max_bytes %d exceeds producer max_message_bytes %d",
+			config.Kafka.Packing.MaxBytes, kafkaConf.Producer.MaxMessageBytes)
+	}
+
+	if err := config.Kafka.PartitionKey.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.Kafka.CircuitBreaker.validate(); err != nil {
+		return nil, err
+	}
+
+	for _, user := range config.Users {
+		if err := user.validate(); err != nil {
+			return nil, fmt.Errorf("invalid user %s: %w", user.Login, err)
+		}
+	}
+
+	routeNames := make(map[string]struct{}, len(config.Routes))
+
+	for idx, route := range config.Routes {
+		if err := route.compile(); err != nil {
+			return nil, fmt.Errorf("invalid route %d: %w", idx, err)
+		}
+
+		if _, ok := routeNames[route.Name]; ok {
+			return nil, fmt.Errorf("duplicate route name: %s", route.Name)
+		}
+
+		routeNames[route.Name] = struct{}{}
+	}
+
+	graphiteNames := make(map[string]struct{}, len(config.Graphite))
+
+	for idx, listener := range config.Graphite {
+		listener.setDefaults()
+
+		if err := listener.validate(); err != nil {
+			return nil, fmt.Errorf("invalid graphite listener %d: %w", idx, err)
+		}
+
+		if _, ok := graphiteNames[listener.Name]; ok {
+			return nil, fmt.Errorf("duplicate graphite listener name: %s", listener.Name)
+		}
+
+		graphiteNames[listener.Name] = struct{}{}
+	}
+
+	statsdNames := make(map[string]struct{}, len(config.StatsD))
+
+	for idx, listener := range config.StatsD {
+		listener.setDefaults()
+
+		if err := listener.validate(); err != nil {
+			return nil, fmt.Errorf("invalid statsd listener %d: %w", idx, err)
+		}
+
+		if _, ok := statsdNames[listener.Name]; ok {
+			return nil, fmt.Errorf("duplicate statsd listener name: %s", listener.Name)
+		}
+
+		statsdNames[listener.Name] = struct{}{}
+	}
+
 	return config, nil
 }
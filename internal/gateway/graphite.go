@@ -0,0 +1,388 @@
+package gateway
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// maxUDPPacketSize is the largest UDP datagram.
+const maxUDPPacketSize = 64 * 1024
+
+// GraphiteConfig is a listener of the Graphite plaintext protocol, "path value [timestamp]" lines over TCP or UDP.
+type GraphiteConfig struct {
+	Name string `yaml:"name"`
+	// ListenTCP and ListenUDP are listen addresses, at least one is required.
+	ListenTCP string `yaml:"listen_tcp"`
+	ListenUDP string `yaml:"listen_udp"`
+	// Topic receives series of the listener instead of kafka.topic, routes still apply.
+	Topic  string `yaml:"topic"`
+	Tenant string `yaml:"tenant"`
+	// FlushInterval is the longest time received series wait before they are published.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// MaxSeries publishes received series before the flush interval once reached.
+	MaxSeries int `yaml:"max_series"`
+	// Mappings convert paths to metric names and labels, evaluated in order.
+	Mappings []*Mapping `yaml:"mappings"`
+}
+
+func (c *GraphiteConfig) setDefaults() {
+	if c.FlushInterval == 0 {
+		c.FlushInterval = time.Second
+	}
+
+	if c.MaxSeries == 0 {
+		c.MaxSeries = 10_000
+	}
+}
+
+func (c *GraphiteConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("graphite listener name is required")
+	}
+
+	if c.ListenTCP == "" && c.ListenUDP == "" {
+		return errors.New("graphite listener requires listen_tcp or listen_udp")
+	}
+
+	if c.FlushInterval < 0 || c.MaxSeries < 0 {
+		return errors.New("graphite listener settings must not be negative")
+	}
+
+	if err := validateTenant(c.Tenant); err != nil {
+		return err
+	}
+
+	for idx, mapping := range c.Mappings {
+		if err := mapping.compile(); err != nil {
+			return fmt.Errorf("invalid mapping %d: %w", idx, err)
+		}
+	}
+
+	return nil
+}
+
+// user returns the user the series of the listener are published as.
+func (c *GraphiteConfig) user() *User {
+	user := &User{Tenant: c.Tenant}
+	if c.Topic != "" {
+		user.Topic = &c.Topic
+	}
+
+	return user
+}
+
+// graphiteListener receives Graphite lines and publishes them in batches.
+type graphiteListener struct {
+	gateway *Gateway
+	config  *GraphiteConfig
+	user    *User
+
+	tcp net.Listener
+	udp net.PacketConn
+
+	series  chan prompb.TimeSeries
+	flushed chan struct{}
+
+	readers sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// startGraphiteListener opens the sockets of the listener and starts receiving lines.
+func (g *Gateway) startGraphiteListener(config *GraphiteConfig) (*graphiteListener, error) {
+	l := &graphiteListener{
+		gateway: g,
+		config:  config,
+		user:    config.user(),
+		series:  make(chan prompb.TimeSeries, config.MaxSeries),
+		flushed: make(chan struct{}),
+		conns:   make(map[net.Conn]struct{}),
+	}
+
+	if config.ListenTCP != "" {
+		tcp, err := net.Listen("tcp", config.ListenTCP)
+		if err != nil {
+			return nil, fmt.Errorf("graphite listener %s: %w", config.Name, err)
+		}
+
+		l.tcp = tcp
+	}
+
+	if config.ListenUDP != "" {
+		udp, err := net.ListenPacket("udp", config.ListenUDP)
+		if err != nil {
+			if l.tcp != nil {
+				l.tcp.Close()
+			}
+
+			return nil, fmt.Errorf("graphite listener %s: %w", config.Name, err)
+		}
+
+		l.udp = udp
+	}
+
+	go l.flushLoop()
+
+	if l.tcp != nil {
+		l.readers.Add(1)
+		go l.acceptTCP()
+	}
+
+	if l.udp != nil {
+		l.readers.Add(1)
+		go l.readUDP()
+	}
+
+	return l, nil
+}
+
+// close stops receiving lines and publishes the pending series.
+func (l *graphiteListener) close() {
+	if l.tcp != nil {
+		l.tcp.Close()
+	}
+
+	if l.udp != nil {
+		l.udp.Close()
+	}
+
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+
+	l.readers.Wait()
+
+	close(l.series)
+	<-l.flushed
+}
+
+func (l *graphiteListener) acceptTCP() {
+	defer l.readers.Done()
+
+	for {
+		conn, err := l.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Printf("graphite listener %s: error accepting connection: %v", l.config.Name, err)
+			time.Sleep(100 * time.Millisecond)
+
+			continue
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+
+			return
+		}
+
+		l.conns[conn] = struct{}{}
+		l.readers.Add(1)
+		l.mu.Unlock()
+
+		go l.readTCP(conn)
+	}
+}
+
+func (l *graphiteListener) readTCP(conn net.Conn) {
+	defer l.readers.Done()
+
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		l.handleLine(scanner.Text(), time.Now())
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("graphite listener %s: error reading from %s: %v", l.config.Name, conn.RemoteAddr(), err)
+	}
+}
+
+func (l *graphiteListener) readUDP() {
+	defer l.readers.Done()
+
+	buf := make([]byte, maxUDPPacketSize)
+
+	for {
+		size, _, err := l.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Printf("graphite listener %s: error reading packet: %v", l.config.Name, err)
+
+			continue
+		}
+
+		now := time.Now()
+
+		for _, line := range strings.Split(string(buf[:size]), "\n") {
+			l.handleLine(line, now)
+		}
+	}
+}
+
+func (l *graphiteListener) handleLine(line string, now time.Time) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	metricGraphiteReceivedLines.WithLabelValues(l.config.Name).Inc()
+
+	ts, keep, err := parseGraphiteLine(line, l.config.Mappings, now)
+	if err != nil {
+		metricGraphiteInvalidLines.WithLabelValues(l.config.Name).Inc()
+		return
+	}
+
+	if !keep {
+		metricGraphiteDroppedSeries.WithLabelValues(l.config.Name, "mapping").Inc()
+		return
+	}
+
+	l.series <- ts
+}
+
+// flushLoop publishes received series every flush interval, or once max_series of them are received.
+func (l *graphiteListener) flushLoop() {
+	defer close(l.flushed)
+
+	ticker := time.NewTicker(l.config.FlushInterval)
+	defer ticker.Stop()
+
+	var (
+		batch      []prompb.TimeSeries
+		receivedAt time.Time
+	)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := l.gateway.publishSeries(l.user, "graphite", receivedAt, batch); err != nil {
+			log.Printf("graphite listener %s: error publishing %d series: %v", l.config.Name, len(batch), err)
+			metricGraphiteDroppedSeries.WithLabelValues(l.config.Name, "publish").Add(float64(len(batch)))
+		} else {
+			metricGraphitePublishedSeries.WithLabelValues(l.config.Name).Add(float64(len(batch)))
+		}
+
+		batch = nil
+	}
+
+	for {
+		select {
+		case ts, ok := <-l.series:
+			if !ok {
+				flush()
+				return
+			}
+
+			if len(batch) == 0 {
+				receivedAt = time.Now()
+			}
+
+			batch = append(batch, ts)
+
+			if len(batch) >= l.config.MaxSeries {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// parseGraphiteLine parses the "path value [timestamp]" line, the path may carry tags as "path;tag=value".
+// The timestamp is in seconds, the receive time is used without it or with -1. Tags become labels,
+// labels of the mapping override them. It returns false when the path is dropped by the mappings.
+func parseGraphiteLine(line string, mappings []*Mapping, now time.Time) (prompb.TimeSeries, bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return prompb.TimeSeries{}, false, fmt.Errorf("expected path, value and timestamp: %q", line)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return prompb.TimeSeries{}, false, fmt.Errorf("invalid value %q: %w", fields[1], err)
+	}
+
+	timestamp := now.UnixMilli()
+
+	if len(fields) == 3 && fields[2] != "-1" {
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return prompb.TimeSeries{}, false, fmt.Errorf("invalid timestamp %q: %w", fields[2], err)
+		}
+
+		timestamp = int64(seconds * 1000)
+	}
+
+	path, tags, err := parseGraphitePath(fields[0])
+	if err != nil {
+		return prompb.TimeSeries{}, false, err
+	}
+
+	name, mapped, keep := mapPath(mappings, path)
+	if !keep {
+		return prompb.TimeSeries{}, false, nil
+	}
+
+	return prompb.TimeSeries{
+		Labels:  buildMappedSeriesLabels(name, tags, mapped),
+		Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+	}, true, nil
+}
+
+// parseGraphitePath splits the tagged path "path;tag=value;..." to the path and labels of the tags.
+func parseGraphitePath(s string) (string, []prompb.Label, error) {
+	path, rawTags, tagged := strings.Cut(s, ";")
+	if path == "" {
+		return "", nil, fmt.Errorf("empty path: %q", s)
+	}
+
+	if !tagged {
+		return path, nil, nil
+	}
+
+	tags := make([]prompb.Label, 0, strings.Count(rawTags, ";")+1)
+
+	for _, tag := range strings.Split(rawTags, ";") {
+		name, value, ok := strings.Cut(tag, "=")
+		if !ok || name == "" || value == "" {
+			return "", nil, fmt.Errorf("invalid tag %q", tag)
+		}
+
+		tags = append(tags, prompb.Label{Name: sanitizeLabelName(name), Value: value})
+	}
+
+	return path, tags, nil
+}
@@ -0,0 +1,225 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGraphiteLine(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+
+	mappings := []*Mapping{
+		{Match: "servers.*.cpu", Name: "server_cpu", Labels: map[string]string{"host": "${1}"}},
+		{Match: "debug.*", Action: mappingActionDrop},
+	}
+
+	for _, mapping := range mappings {
+		require.NoError(t, mapping.compile())
+	}
+
+	tests := []struct {
+		name   string
+		line   string
+		series prompb.TimeSeries
+		keep   bool
+	}{
+		{
+			name: "timestamp",
+			line: "servers.web-1.cpu 0.5 1699999990",
+			series: prompb.TimeSeries{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "server_cpu"},
+					{Name: "host", Value: "web-1"},
+				},
+				Samples: []prompb.Sample{{Value: 0.5, Timestamp: 1_699_999_990_000}},
+			},
+			keep: true,
+		},
+		{
+			name: "receive time",
+			line: "disk.used 42",
+			series: prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "disk_used"}},
+				Samples: []prompb.Sample{{Value: 42, Timestamp: 1_700_000_000_000}},
+			},
+			keep: true,
+		},
+		{
+			name: "tags",
+			line: "servers.web-1.cpu;host=ignored;data.center=eu 1 -1",
+			series: prompb.TimeSeries{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "server_cpu"},
+					{Name: "data_center", Value: "eu"},
+					{Name: "host", Value: "web-1"},
+				},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1_700_000_000_000}},
+			},
+			keep: true,
+		},
+		{name: "dropped", line: "debug.gc 1 1699999990", keep: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series, keep, err := parseGraphiteLine(tt.line, mappings, now)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.keep, keep)
+			assert.Equal(t, tt.series, series)
+		})
+	}
+
+	for _, line := range []string{
+		"disk.used",
+		"disk.used 1 2 3",
+		"disk.used x",
+		"disk.used 1 x",
+		";env=prod 1",
+		"disk.used;env 1",
+		"disk.used;=prod 1",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, _, err := parseGraphiteLine(line, nil, now)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGraphiteListener(t *testing.T) {
+	config := &GraphiteConfig{
+		Name:      "test-listener",
+		ListenTCP: "127.0.0.1:0",
+		ListenUDP: "127.0.0.1:0",
+		Topic:     "graphite",
+		Tenant:    "legacy",
+		// series are published when the listener is closed
+		FlushInterval: time.Hour,
+	}
+	config.setDefaults()
+	require.NoError(t, config.validate())
+
+	g, producer := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "metrics"}, Graphite: []*GraphiteConfig{config}})
+
+	var names []string
+
+	for range 3 {
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			assert.Equal(t, "graphite", msg.Topic)
+
+			meta := parseTestEnvelope(t, msg)
+			assert.Equal(t, "graphite", meta.Protocol)
+			assert.Equal(t, "legacy", meta.Tenant)
+
+			value, err := msg.Value.Encode()
+			if err != nil {
+				return err
+			}
+
+			ts := &prompb.TimeSeries{}
+			if err := proto.Unmarshal(value, ts); err != nil {
+				return err
+			}
+
+			names = append(names, getMetricName(ts.Labels))
+
+			return nil
+		})
+	}
+
+	received := testutil.ToFloat64(metricGraphiteReceivedLines.WithLabelValues("test-listener"))
+	invalid := testutil.ToFloat64(metricGraphiteInvalidLines.WithLabelValues("test-listener"))
+	published := testutil.ToFloat64(metricGraphitePublishedSeries.WithLabelValues("test-listener"))
+
+	listener, err := g.startGraphiteListener(config)
+	require.NoError(t, err)
+
+	tcpConn, err := net.Dial("tcp", listener.tcp.Addr().String())
+	require.NoError(t, err)
+
+	_, err = fmt.Fprint(tcpConn, "disk.used 42 1700000000\nnot a valid line\nload.1m;host=a 0.5\n")
+	require.NoError(t, err)
+	require.NoError(t, tcpConn.Close())
+
+	udpConn, err := net.Dial("udp", listener.udp.LocalAddr().String())
+	require.NoError(t, err)
+
+	_, err = fmt.Fprint(udpConn, "net.rx 100 1700000000\n")
+	require.NoError(t, err)
+	require.NoError(t, udpConn.Close())
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metricGraphiteReceivedLines.WithLabelValues("test-listener"))-received == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	listener.close()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricGraphiteInvalidLines.WithLabelValues("test-listener"))-invalid)
+	assert.Equal(t, 3.0, testutil.ToFloat64(metricGraphitePublishedSeries.WithLabelValues("test-listener"))-published)
+	require.NoError(t, producer.Close())
+
+	// the message checker runs on the producer goroutine until it is closed
+	assert.ElementsMatch(t, []string{"disk_used", "load_1m", "net_rx"}, names)
+}
+
+func TestGraphiteConfigValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		graphite string
+	}{
+		{
+			name: "missing name",
+			graphite: `
+  - listen_tcp: ":2003"`,
+		},
+		{
+			name: "missing listen address",
+			graphite: `
+  - name: a`,
+		},
+		{
+			name: "invalid tenant",
+			graphite: `
+  - name: a
+    listen_tcp: ":2003"
+    tenant: "a/b"`,
+		},
+		{
+			name: "invalid mapping",
+			graphite: `
+  - name: a
+    listen_tcp: ":2003"
+    mappings:
+      - match: "servers.*"`,
+		},
+		{
+			name: "duplicate name",
+			graphite: `
+  - name: a
+    listen_tcp: ":2003"
+  - name: a
+    listen_udp: ":2003"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte("graphite:"+tt.graphite+"\n"), 0o600))
+
+			_, err := loadConfig(path)
+			assert.Error(t, err)
+		})
+	}
+}
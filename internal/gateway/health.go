@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func healthyHandler(c *gin.Context) {
+	c.String(http.StatusOK, "prometheus-mimic-gateway is healthy")
+}
+
+// readyHandler reports whether the gateway can accept writes.
+func (g *Gateway) readyHandler(c *gin.Context) {
+	if err := g.ready(); err != nil {
+		c.String(http.StatusServiceUnavailable, "prometheus-mimic-gateway is not ready: %v", err)
+		return
+	}
+
+	c.String(http.StatusOK, "prometheus-mimic-gateway is ready")
+}
+
+// ready checks that metadata of the topics the gateway produces to is available from the brokers
+// and that none of their circuit breakers is open. With the spool enabled, writes are accepted
+// while the breakers are open, so they do not affect readiness.
+func (g *Gateway) ready() error {
+	if err := g.kafkaClient.RefreshMetadata(g.topics()...); err != nil {
+		return fmt.Errorf("kafka metadata is not available: %w", err)
+	}
+
+	if g.spool != nil {
+		return nil
+	}
+
+	if topics := g.breakers.open(); len(topics) > 0 {
+		return fmt.Errorf("circuit breaker is open for kafka topics: %s", strings.Join(topics, ", "))
+	}
+
+	return nil
+}
+
+// topics returns the configured topics of the gateway.
+func (g *Gateway) topics() []string {
+	topics := []string{g.config.Kafka.Topic, g.config.Kafka.MetadataTopic}
+
+	for _, user := range g.config.Users {
+		if user.Topic != nil {
+			topics = append(topics, *user.Topic)
+		}
+	}
+
+	for _, route := range g.config.Routes {
+		topics = append(topics, route.Topic)
+	}
+
+	for _, listener := range g.config.Graphite {
+		topics = append(topics, listener.Topic)
+	}
+
+	for _, listener := range g.config.StatsD {
+		topics = append(topics, listener.Topic)
+	}
+
+	topics = slices.DeleteFunc(topics, func(topic string) bool { return topic == "" })
+	slices.Sort(topics)
+
+	return slices.Compact(topics)
+}
@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		g, _ := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "health-ready"}})
+
+		assert.Equal(t, http.StatusOK, serveTestRoute("/-/healthy", httptest.NewRequest(http.MethodGet, "/-/healthy", nil), healthyHandler).Code)
+		assert.Equal(t, http.StatusOK, serveTestRoute("/-/ready", httptest.NewRequest(http.MethodGet, "/-/ready", nil), g.readyHandler).Code)
+	})
+
+	t.Run("circuit breaker is open", func(t *testing.T) {
+		g, _ := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "health-open"}})
+		setTestBreakerState(g.breakers.get("health-open"), breakerOpen)
+
+		w := serveTestRoute("/-/ready", httptest.NewRequest(http.MethodGet, "/-/ready", nil), g.readyHandler)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), "health-open")
+
+		assert.Equal(t, http.StatusOK, serveTestRoute("/-/healthy", httptest.NewRequest(http.MethodGet, "/-/healthy", nil), healthyHandler).Code)
+	})
+
+	t.Run("circuit breaker is open with spool", func(t *testing.T) {
+		g, _ := newTestGateway(t, &Config{
+			Kafka: KafkaConfig{Topic: "health-spool"},
+			Spool: SpoolConfig{Path: t.TempDir()},
+		})
+		setTestBreakerState(g.breakers.get("health-spool"), breakerOpen)
+
+		assert.Equal(t, http.StatusOK, serveTestRoute("/-/ready", httptest.NewRequest(http.MethodGet, "/-/ready", nil), g.readyHandler).Code)
+	})
+
+	t.Run("kafka is not reachable", func(t *testing.T) {
+		g, _ := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "health-kafka"}})
+		g.kafkaClient.Close()
+
+		w := serveTestRoute("/-/ready", httptest.NewRequest(http.MethodGet, "/-/ready", nil), g.readyHandler)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), "kafka metadata is not available")
+	})
+}
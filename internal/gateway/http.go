@@ -18,6 +18,12 @@ const (
 	maxInsertRequestSize = 128 * 1024 * 1024 // 128 MB
 )
 
+// protocolListener receives metrics outside of the HTTP server.
+type protocolListener interface {
+	// close stops receiving and publishes the pending series.
+	close()
+}
+
 func (g *Gateway) ListenAndServe() error {
 	router := gin.New()
 	router.Use(gin.Recovery())
@@ -29,7 +35,21 @@ func (g *Gateway) ListenAndServe() error {
 
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-	router.POST("/api/v1/write", g.basicAuthMiddleware(), writeHeadersMiddleware, g.writeHandler)
+	router.GET("/-/healthy", healthyHandler)
+	router.GET("/-/ready", g.readyHandler)
+
+	router.POST("/api/v1/write", g.basicAuthMiddleware(), tenantMiddleware, writeHeadersMiddleware, g.writeHandler)
+
+	// InfluxDB 1.x and 2.x line protocol
+	router.POST("/write", g.basicAuthMiddleware(), tenantMiddleware, g.influxWriteHandler)
+	router.POST("/api/v2/write", g.influxAuthMiddleware(), tenantMiddleware, g.influxWriteHandler)
+
+	// VictoriaMetrics JSON line and Prometheus text imports
+	router.POST("/api/v1/import", g.basicAuthMiddleware(), tenantMiddleware, g.importHandler("victoriametrics_import", parseJSONLineImport))
+	router.POST("/api/v1/import/prometheus", g.basicAuthMiddleware(), tenantMiddleware, g.importHandler("prometheus_import", parsePrometheusImport))
+
+	// OpenTelemetry OTLP/HTTP
+	router.POST("/v1/metrics", g.basicAuthMiddleware(), tenantMiddleware, g.otlpWriteHandler)
 
 	listenAddr, ok := os.LookupEnv("LISTEN_ADDRESS")
 	if !ok {
@@ -41,9 +61,14 @@ func (g *Gateway) ListenAndServe() error {
 		Handler: router.Handler(),
 	}
 
+	listeners, err := g.startListeners()
+	if err != nil {
+		g.closeKafka()
+		return err
+	}
+
 	go func() {
-		defer g.kafkaProducer.Close()
-		defer g.kafkaClient.Close()
+		defer g.closeKafka()
 
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
@@ -55,6 +80,11 @@ func (g *Gateway) ListenAndServe() error {
 	<-quit
 	log.Println("shutdown server ...")
 
+	// listeners publish pending series before the producer is closed after the server shutdown
+	for _, listener := range listeners {
+		listener.close()
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -64,3 +94,47 @@ func (g *Gateway) ListenAndServe() error {
 
 	return nil
 }
+
+// startListeners starts the Graphite and StatsD listeners. When one of them fails to start,
+// the listeners already started are closed.
+func (g *Gateway) startListeners() ([]protocolListener, error) {
+	listeners := make([]protocolListener, 0, len(g.config.Graphite)+len(g.config.StatsD))
+
+	closeListeners := func() {
+		for _, listener := range listeners {
+			listener.close()
+		}
+	}
+
+	for _, config := range g.config.Graphite {
+		listener, err := g.startGraphiteListener(config)
+		if err != nil {
+			closeListeners()
+			return nil, err
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	for _, config := range g.config.StatsD {
+		listener, err := g.startStatsDListener(config)
+		if err != nil {
+			closeListeners()
+			return nil, err
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// closeKafka closes the kafka producer and client, and the spool of failed messages.
+func (g *Gateway) closeKafka() {
+	g.kafkaProducer.Close()
+	g.kafkaClient.Close()
+
+	if g.spool != nil {
+		g.spool.Close()
+	}
+}
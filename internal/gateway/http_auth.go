@@ -9,6 +9,16 @@ import (
 )
 
 func (g *Gateway) basicAuthMiddleware() gin.HandlerFunc {
+	return g.authMiddleware(false)
+}
+
+// influxAuthMiddleware also accepts "Authorization: Token <token>" sent by InfluxDB 2.x clients,
+// the token is either "login:password" as in InfluxDB 1.8 compatibility or the password of a user.
+func (g *Gateway) influxAuthMiddleware() gin.HandlerFunc {
+	return g.authMiddleware(true)
+}
+
+func (g *Gateway) authMiddleware(acceptToken bool) gin.HandlerFunc {
 	if g.config.Users == nil {
 		return func(c *gin.Context) {
 			c.Set("user", &User{})
@@ -24,29 +34,15 @@ func (g *Gateway) basicAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		split := strings.SplitN(auth, " ", 2)
-		if len(split) != 2 || strings.ToLower(split[0]) != "basic" {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-
-		payload, err := base64.StdEncoding.DecodeString(split[1])
-		if err != nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-
-		pair := strings.SplitN(string(payload), ":", 2)
-		if len(pair) != 2 {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-
 		var authenticatedUser *User
-		for _, user := range g.config.Users {
-			if user.Login == pair[0] && user.Password == pair[1] {
-				authenticatedUser = &user
-				break
+
+		scheme, credentials, _ := strings.Cut(auth, " ")
+		switch strings.ToLower(scheme) {
+		case "basic":
+			authenticatedUser = g.basicAuthUser(credentials)
+		case "token":
+			if acceptToken {
+				authenticatedUser = g.tokenUser(credentials)
 			}
 		}
 
@@ -59,3 +55,55 @@ func (g *Gateway) basicAuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// basicAuthUser returns the user of base64 encoded "login:password" credentials or nil.
+func (g *Gateway) basicAuthUser(credentials string) *User {
+	payload, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return nil
+	}
+
+	login, password, ok := strings.Cut(string(payload), ":")
+	if !ok {
+		return nil
+	}
+
+	return g.findUser(login, password)
+}
+
+// tokenUser returns the user of the "login:password" token, or the only user with the token as password, or nil.
+func (g *Gateway) tokenUser(token string) *User {
+	if login, password, ok := strings.Cut(token, ":"); ok {
+		return g.findUser(login, password)
+	}
+
+	if token == "" {
+		return nil
+	}
+
+	var tokenUser *User
+	for _, user := range g.config.Users {
+		if user.Password != token {
+			continue
+		}
+
+		if tokenUser != nil {
+			// the token is ambiguous, the login must be given
+			return nil
+		}
+
+		tokenUser = &user
+	}
+
+	return tokenUser
+}
+
+func (g *Gateway) findUser(login, password string) *User {
+	for _, user := range g.config.Users {
+		if user.Login == login && user.Password == password {
+			return &user
+		}
+	}
+
+	return nil
+}
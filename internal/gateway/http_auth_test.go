@@ -16,6 +16,7 @@ func TestBasicAuthMiddleware(t *testing.T) {
 		name           string
 		users          []User
 		authHeader     string
+		influx         bool
 		expectedStatus int
 		expectedUser   *User
 	}{
@@ -75,13 +76,64 @@ func TestBasicAuthMiddleware(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedUser:   &User{Login: "user1", Password: "pass1"},
 		},
+		{
+			name:           "Token is rejected without influx",
+			users:          []User{{Login: "user1", Password: "pass1"}},
+			authHeader:     "Token user1:pass1",
+			expectedStatus: http.StatusUnauthorized,
+			expectedUser:   nil,
+		},
+		{
+			name:           "Influx token with login",
+			users:          []User{{Login: "user1", Password: "pass1"}, {Login: "user2", Password: "pass2"}},
+			authHeader:     "Token user2:pass2",
+			influx:         true,
+			expectedStatus: http.StatusOK,
+			expectedUser:   &User{Login: "user2", Password: "pass2"},
+		},
+		{
+			name:           "Influx token with password",
+			users:          []User{{Login: "user1", Password: "pass1"}, {Login: "user2", Password: "pass2"}},
+			authHeader:     "Token pass1",
+			influx:         true,
+			expectedStatus: http.StatusOK,
+			expectedUser:   &User{Login: "user1", Password: "pass1"},
+		},
+		{
+			name:           "Influx token shared by users",
+			users:          []User{{Login: "user1", Password: "pass"}, {Login: "user2", Password: "pass"}},
+			authHeader:     "Token pass",
+			influx:         true,
+			expectedStatus: http.StatusUnauthorized,
+			expectedUser:   nil,
+		},
+		{
+			name:           "Invalid influx token",
+			users:          []User{{Login: "user1", Password: "pass1"}},
+			authHeader:     "Token wrongpass",
+			influx:         true,
+			expectedStatus: http.StatusUnauthorized,
+			expectedUser:   nil,
+		},
+		{
+			name:           "Basic auth with influx",
+			users:          []User{{Login: "user1", Password: "pass1"}},
+			authHeader:     "Basic " + base64.StdEncoding.EncodeToString([]byte("user1:pass1")),
+			influx:         true,
+			expectedStatus: http.StatusOK,
+			expectedUser:   &User{Login: "user1", Password: "pass1"},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			g := &Gateway{config: &Config{Users: tt.users}}
 			router := gin.New()
-			router.Use(g.basicAuthMiddleware())
+			if tt.influx {
+				router.Use(g.influxAuthMiddleware())
+			} else {
+				router.Use(g.basicAuthMiddleware())
+			}
 			router.GET("/test", func(c *gin.Context) {
 				user, _ := c.Get("user")
 				c.JSON(http.StatusOK, user)
@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// importSeries is a line of the VictoriaMetrics JSON line format, the format of /api/v1/export.
+// Null values are staleness markers.
+type importSeries struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []*float64        `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+// importHandler returns the handler of the import endpoint parsing the body of the given format.
+// The body is sent plain or with gzip encoding.
+func (g *Gateway) importHandler(protocol string, parse func(data []byte, now time.Time) (*prompb.WriteRequest, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+
+		metricWriteBatchesRequests.Inc()
+
+		authenticatedUser := c.MustGet("user").(*User)
+
+		body, ok := readRequestBody(c)
+		if !ok {
+			return
+		}
+
+		body, ok = decodeGzipRequestBody(c, body)
+		if !ok {
+			return
+		}
+
+		req, err := parse(body, started)
+		if err != nil {
+			c.String(http.StatusBadRequest, "error parsing import request: %v", err)
+			return
+		}
+
+		if _, ok := g.publishWriteRequest(c, authenticatedUser, protocol, started, req); !ok {
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// parseJSONLineImport converts series of the VictoriaMetrics JSON line format.
+func parseJSONLineImport(data []byte, _ time.Time) (*prompb.WriteRequest, error) {
+	req := &prompb.WriteRequest{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	for idx := 1; ; idx++ {
+		var series importSeries
+		if err := decoder.Decode(&series); err != nil {
+			if errors.Is(err, io.EOF) {
+				return req, nil
+			}
+
+			return nil, fmt.Errorf("series %d: %w", idx, err)
+		}
+
+		if len(series.Metric) == 0 {
+			return nil, fmt.Errorf("series %d: missing metric", idx)
+		}
+
+		if len(series.Values) != len(series.Timestamps) {
+			return nil, fmt.Errorf("series %d: %d values do not match %d timestamps", idx, len(series.Values), len(series.Timestamps))
+		}
+
+		ts := prompb.TimeSeries{
+			Labels:  make([]prompb.Label, 0, len(series.Metric)),
+			Samples: make([]prompb.Sample, 0, len(series.Values)),
+		}
+
+		for name, value := range series.Metric {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: name, Value: value})
+		}
+
+		slices.SortFunc(ts.Labels, func(a, b prompb.Label) int { return strings.Compare(a.Name, b.Name) })
+
+		for sampleIdx, sampleValue := range series.Values {
+			sample := prompb.Sample{Value: math.Float64frombits(value.StaleNaN), Timestamp: series.Timestamps[sampleIdx]}
+			if sampleValue != nil {
+				sample.Value = *sampleValue
+			}
+
+			ts.Samples = append(ts.Samples, sample)
+		}
+
+		req.Timeseries = append(req.Timeseries, ts)
+	}
+}
+
+// parsePrometheusImport converts the Prometheus text exposition format. Samples without a timestamp get
+// the receive time, HELP and TYPE lines are converted to metadata.
+func parsePrometheusImport(data []byte, now time.Time) (*prompb.WriteRequest, error) {
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+
+	req := &prompb.WriteRequest{}
+
+	metadata := make(map[string]*prompb.MetricMetadata)
+
+	getMetadata := func(name []byte) *prompb.MetricMetadata {
+		family, ok := metadata[string(name)]
+		if !ok {
+			family = &prompb.MetricMetadata{MetricFamilyName: string(name)}
+			metadata[family.MetricFamilyName] = family
+		}
+
+		return family
+	}
+
+	parser := textparse.NewPromParser(data, labels.NewSymbolTable())
+
+	for {
+		entry, err := parser.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		switch entry {
+		case textparse.EntryHelp:
+			name, help := parser.Help()
+			getMetadata(name).Help = string(help)
+
+		case textparse.EntryType:
+			name, metricType := parser.Type()
+			getMetadata(name).Type = prompb.FromMetadataType(metricType)
+
+		case textparse.EntrySeries:
+			_, timestamp, value := parser.Series()
+
+			var seriesLabels labels.Labels
+			parser.Labels(&seriesLabels)
+
+			sample := prompb.Sample{Value: value, Timestamp: now.UnixMilli()}
+			if timestamp != nil {
+				sample.Timestamp = *timestamp
+			}
+
+			req.Timeseries = append(req.Timeseries, prompb.TimeSeries{
+				Labels:  prompb.FromLabels(seriesLabels, nil),
+				Samples: []prompb.Sample{sample},
+			})
+		}
+	}
+
+	for _, family := range metadata {
+		req.Metadata = append(req.Metadata, *family)
+	}
+
+	slices.SortFunc(req.Metadata, func(a, b prompb.MetricMetadata) int {
+		return strings.Compare(a.MetricFamilyName, b.MetricFamilyName)
+	})
+
+	return req, nil
+}
@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJSONLineImport(t *testing.T) {
+	data := `{"metric":{"__name__":"up","job":"api","instance":"a:9100"},"values":[1,0.5],"timestamps":[1700000000000,1700000015000]}
+{"metric":{"__name__":"build_info"},"values":[1],"timestamps":[1700000000000]}
+`
+
+	req, err := parseJSONLineImport([]byte(data), time.Now())
+	require.NoError(t, err)
+
+	assert.Equal(t, []prompb.TimeSeries{
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "up"},
+				{Name: "instance", Value: "a:9100"},
+				{Name: "job", Value: "api"},
+			},
+			Samples: []prompb.Sample{
+				{Value: 1, Timestamp: 1_700_000_000_000},
+				{Value: 0.5, Timestamp: 1_700_000_015_000},
+			},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "build_info"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1_700_000_000_000}},
+		},
+	}, req.Timeseries)
+
+	t.Run("staleness marker", func(t *testing.T) {
+		req, err := parseJSONLineImport([]byte(`{"metric":{"__name__":"up"},"values":[1,null],"timestamps":[1700000000000,1700000015000]}`), time.Now())
+		require.NoError(t, err)
+		require.Len(t, req.Timeseries, 1)
+
+		samples := req.Timeseries[0].Samples
+		require.Len(t, samples, 2)
+		assert.Equal(t, prompb.Sample{Value: 1, Timestamp: 1_700_000_000_000}, samples[0])
+		assert.True(t, value.IsStaleNaN(samples[1].Value))
+		assert.Equal(t, int64(1_700_000_015_000), samples[1].Timestamp)
+	})
+
+	for _, data := range []string{
+		`{"metric":{"__name__":"up"},"values":[1,2],"timestamps":[1]}`,
+		`{"values":[1],"timestamps":[1]}`,
+		`{"metric":{"__name__":"up"},"values":["x"],"timestamps":[1]}`,
+		`{"metric":`,
+	} {
+		t.Run(data, func(t *testing.T) {
+			_, err := parseJSONLineImport([]byte(data), time.Now())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParsePrometheusImport(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+
+	data := `# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{code="200",method="get"} 1027 1699999990000
+http_requests_total{code="400",method="post"} 3
+# TYPE temperature gauge
+temperature 21.5`
+
+	req, err := parsePrometheusImport([]byte(data), now)
+	require.NoError(t, err)
+
+	assert.Equal(t, []prompb.TimeSeries{
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "http_requests_total"},
+				{Name: "code", Value: "200"},
+				{Name: "method", Value: "get"},
+			},
+			Samples: []prompb.Sample{{Value: 1027, Timestamp: 1_699_999_990_000}},
+		},
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "http_requests_total"},
+				{Name: "code", Value: "400"},
+				{Name: "method", Value: "post"},
+			},
+			Samples: []prompb.Sample{{Value: 3, Timestamp: 1_700_000_000_000}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "temperature"}},
+			Samples: []prompb.Sample{{Value: 21.5, Timestamp: 1_700_000_000_000}},
+		},
+	}, req.Timeseries)
+
+	assert.Equal(t, []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "http_requests_total", Help: "Requests served."},
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "temperature"},
+	}, req.Metadata)
+
+	_, err = parsePrometheusImport([]byte("up{job=\"api\" 1\n"), now)
+	assert.Error(t, err)
+}
+
+func TestImportHandler(t *testing.T) {
+	g, producer := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "metrics"}})
+
+	producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "victoriametrics_import", parseTestEnvelope(t, msg).Protocol)
+		return nil
+	})
+
+	serveImport := func(body string) *httptest.ResponseRecorder {
+		return serveTestRoute("/api/v1/import", httptest.NewRequest(http.MethodPost, "/api/v1/import", bytes.NewBufferString(body)),
+			g.basicAuthMiddleware(), tenantMiddleware, g.importHandler("victoriametrics_import", parseJSONLineImport))
+	}
+
+	assert.Equal(t, http.StatusNoContent, serveImport(`{"metric":{"__name__":"up"},"values":[1],"timestamps":[1700000000000]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serveImport("{").Code)
+	assert.NoError(t, producer.Close())
+}
@@ -0,0 +1,280 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// influxUnescaper removes escaping of measurements, tag keys, tag values and field keys.
+var influxUnescaper = strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ", `\\`, `\`)
+
+// influxWriteHandler accepts InfluxDB line protocol of the /write (1.x) and /api/v2/write (2.x) endpoints.
+// Every numeric field is converted to the series named "measurement_field" with tags as labels.
+func (g *Gateway) influxWriteHandler(c *gin.Context) {
+	started := time.Now()
+
+	metricWriteBatchesRequests.Inc()
+
+	authenticatedUser := c.MustGet("user").(*User)
+
+	precision, err := parseInfluxPrecision(c.DefaultQuery("precision", "ns"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	body, ok := readRequestBody(c)
+	if !ok {
+		return
+	}
+
+	body, ok = decodeGzipRequestBody(c, body)
+	if !ok {
+		return
+	}
+
+	req, err := parseInfluxLines(body, precision, started)
+	if err != nil {
+		c.String(http.StatusBadRequest, "error parsing line protocol: %v", err)
+		return
+	}
+
+	if _, ok := g.publishWriteRequest(c, authenticatedUser, "influx", started, req); !ok {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// parseInfluxPrecision returns the duration of the timestamp unit, accepting precisions of both API versions.
+func parseInfluxPrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("unsupported precision: %s", precision)
+	}
+}
+
+// parseInfluxLines converts line protocol to series. Points without a timestamp get the receive time,
+// string fields are skipped and booleans are converted to 0 and 1.
+func parseInfluxLines(data []byte, precision time.Duration, now time.Time) (*prompb.WriteRequest, error) {
+	req := &prompb.WriteRequest{}
+
+	for idx, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		series, err := parseInfluxLine(string(line), precision, now)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", idx+1, err)
+		}
+
+		req.Timeseries = append(req.Timeseries, series...)
+	}
+
+	return req, nil
+}
+
+func parseInfluxLine(line string, precision time.Duration, now time.Time) ([]prompb.TimeSeries, error) {
+	keyEnd := indexInfluxUnescaped(line, ' ', false)
+	if keyEnd < 0 {
+		return nil, errors.New("missing fields")
+	}
+
+	key, rest := line[:keyEnd], strings.TrimLeft(line[keyEnd+1:], " ")
+
+	fieldsEnd := indexInfluxUnescaped(rest, ' ', true)
+	if fieldsEnd < 0 {
+		fieldsEnd = len(rest)
+	}
+
+	fields, timestamp := rest[:fieldsEnd], strings.TrimSpace(rest[fieldsEnd:])
+
+	timestampMs := now.UnixMilli()
+
+	if timestamp != "" {
+		value, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %s", timestamp)
+		}
+
+		if precision >= time.Millisecond {
+			timestampMs = value * int64(precision/time.Millisecond)
+		} else {
+			timestampMs = value / int64(time.Millisecond/precision)
+		}
+	}
+
+	keyParts := splitInfluxUnescaped(key, ',', false)
+
+	measurement := influxUnescaper.Replace(keyParts[0])
+	if measurement == "" {
+		return nil, errors.New("missing measurement")
+	}
+
+	labels := make([]prompb.Label, 0, len(keyParts))
+	// tagNames are the tag keys by their label names, to reject tags written as the same label
+	tagNames := make(map[string]string, len(keyParts))
+
+	for _, tag := range keyParts[1:] {
+		name, value, ok := cutInfluxUnescaped(tag)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid tag: %s", tag)
+		}
+
+		// tags with empty values are dropped, the same as series without the label
+		if value == "" {
+			continue
+		}
+
+		name = influxUnescaper.Replace(name)
+		labelName := sanitizeLabelName(name)
+
+		// names starting with "__" are reserved for the metric name and internal labels
+		if strings.HasPrefix(labelName, "__") {
+			return nil, fmt.Errorf("tag %s uses the reserved label name %s", name, labelName)
+		}
+
+		if other, ok := tagNames[labelName]; ok {
+			return nil, fmt.Errorf("tags %s and %s are both written as label %s", other, name, labelName)
+		}
+
+		tagNames[labelName] = name
+
+		labels = append(labels, prompb.Label{
+			Name:  labelName,
+			Value: influxUnescaper.Replace(value),
+		})
+	}
+
+	var series []prompb.TimeSeries
+
+	for _, field := range splitInfluxUnescaped(fields, ',', true) {
+		name, rawValue, ok := cutInfluxUnescaped(field)
+		if !ok || name == "" || rawValue == "" {
+			return nil, fmt.Errorf("invalid field: %s", field)
+		}
+
+		value, numeric, err := parseInfluxFieldValue(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of field %s: %w", name, err)
+		}
+
+		if !numeric {
+			continue
+		}
+
+		seriesLabels := append([]prompb.Label{{
+			Name:  "__name__",
+			Value: sanitizeMetricName(measurement + "_" + influxUnescaper.Replace(name)),
+		}}, labels...)
+
+		slices.SortFunc(seriesLabels, func(a, b prompb.Label) int { return strings.Compare(a.Name, b.Name) })
+
+		series = append(series, prompb.TimeSeries{
+			Labels:  seriesLabels,
+			Samples: []prompb.Sample{{Value: value, Timestamp: timestampMs}},
+		})
+	}
+
+	return series, nil
+}
+
+// parseInfluxFieldValue returns the numeric value of the field, string fields are not numeric.
+func parseInfluxFieldValue(value string) (float64, bool, error) {
+	if value[0] == '"' {
+		if len(value) < 2 || value[len(value)-1] != '"' {
+			return 0, false, errors.New("unterminated string")
+		}
+
+		return 0, false, nil
+	}
+
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+
+	switch value[len(value)-1] {
+	case 'i':
+		parsed, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		return float64(parsed), true, err
+
+	case 'u':
+		parsed, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+		return float64(parsed), true, err
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+
+	return parsed, true, err
+}
+
+// indexInfluxUnescaped returns the index of the first separator not escaped with a backslash,
+// and with quoted set, not inside a string field value.
+func indexInfluxUnescaped(s string, sep byte, quoted bool) int {
+	var inString bool
+
+	for idx := 0; idx < len(s); idx++ {
+		switch {
+		case s[idx] == '\\':
+			idx++
+
+		case s[idx] == '"' && quoted:
+			inString = !inString
+
+		case s[idx] == sep && !inString:
+			return idx
+		}
+	}
+
+	return -1
+}
+
+func splitInfluxUnescaped(s string, sep byte, quoted bool) []string {
+	var parts []string
+
+	for {
+		idx := indexInfluxUnescaped(s, sep, quoted)
+		if idx < 0 {
+			return append(parts, s)
+		}
+
+		parts = append(parts, s[:idx])
+		s = s[idx+1:]
+	}
+}
+
+// cutInfluxUnescaped splits the tag or the field at the first unescaped equals sign.
+func cutInfluxUnescaped(s string) (string, string, bool) {
+	idx := indexInfluxUnescaped(s, '=', false)
+	if idx < 0 {
+		return "", "", false
+	}
+
+	return s[:idx], s[idx+1:], true
+}
@@ -0,0 +1,180 @@
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInfluxLines(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+
+	tests := []struct {
+		name      string
+		data      string
+		precision time.Duration
+		want      []prompb.TimeSeries
+	}{
+		{
+			name:      "fields and tags",
+			data:      "cpu,host=server01,region=eu usage_idle=92.5,usage_user=3i 1700000000000000000\n",
+			precision: time.Nanosecond,
+			want: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "cpu_usage_idle"},
+						{Name: "host", Value: "server01"},
+						{Name: "region", Value: "eu"},
+					},
+					Samples: []prompb.Sample{{Value: 92.5, Timestamp: 1_700_000_000_000}},
+				},
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "cpu_usage_user"},
+						{Name: "host", Value: "server01"},
+						{Name: "region", Value: "eu"},
+					},
+					Samples: []prompb.Sample{{Value: 3, Timestamp: 1_700_000_000_000}},
+				},
+			},
+		},
+		{
+			name:      "escaping, strings and booleans",
+			data:      "# comment\n\ndisk\\ io,path=/var\\,log,Dev-Name=sda ok=true,msg=\"a b,c=d\",free=10u 1700000000\n",
+			precision: time.Second,
+			want: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "Dev_Name", Value: "sda"},
+						{Name: "__name__", Value: "disk_io_ok"},
+						{Name: "path", Value: "/var,log"},
+					},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: 1_700_000_000_000}},
+				},
+				{
+					Labels: []prompb.Label{
+						{Name: "Dev_Name", Value: "sda"},
+						{Name: "__name__", Value: "disk_io_free"},
+						{Name: "path", Value: "/var,log"},
+					},
+					Samples: []prompb.Sample{{Value: 10, Timestamp: 1_700_000_000_000}},
+				},
+			},
+		},
+		{
+			name:      "receive time without timestamp",
+			data:      "mem used=1",
+			precision: time.Nanosecond,
+			want: []prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "mem_used"}},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: 1_700_000_000_000}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parseInfluxLines([]byte(tt.data), tt.precision, now)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, req.Timeseries)
+		})
+	}
+
+	for _, data := range []string{
+		"cpu",
+		"cpu,host usage=1",
+		"cpu usage=abc",
+		"cpu usage=1 yesterday",
+		"cpu msg=\"unterminated",
+		"cpu,__name__=mem usage=1",
+		"cpu,host-name=a,host.name=b usage=1",
+		"cpu,host=a,host=b usage=1",
+	} {
+		t.Run(data, func(t *testing.T) {
+			_, err := parseInfluxLines([]byte(data), time.Nanosecond, now)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// serveTestInfluxWrite serves the request to the /write or /api/v2/write endpoint.
+func serveTestInfluxWrite(g *Gateway, req *http.Request) *httptest.ResponseRecorder {
+	auth := g.basicAuthMiddleware()
+	if req.URL.Path == "/api/v2/write" {
+		auth = g.influxAuthMiddleware()
+	}
+
+	return serveTestRoute(req.URL.Path, req, auth, tenantMiddleware, g.influxWriteHandler)
+}
+
+func TestInfluxWriteHandler(t *testing.T) {
+	t.Run("gzip body with precision", func(t *testing.T) {
+		g, producer := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "metrics"}})
+
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			data, err := msg.Value.Encode()
+			require.NoError(t, err)
+
+			var ts prompb.TimeSeries
+			require.NoError(t, proto.Unmarshal(data, &ts))
+
+			assert.Equal(t, "influx", parseTestEnvelope(t, msg).Protocol)
+			assert.Equal(t, "cpu_usage_idle", getMetricName(ts.Labels))
+			assert.Equal(t, int64(1_700_000_000_000), ts.Samples[0].Timestamp)
+			return nil
+		})
+
+		var body bytes.Buffer
+		writer := gzip.NewWriter(&body)
+		_, err := writer.Write([]byte("cpu,host=server01 usage_idle=92.5 1700000000000\n"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/write?bucket=telegraf&precision=ms", &body)
+		req.Header.Set("Content-Encoding", "gzip")
+
+		w := serveTestInfluxWrite(g, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.NoError(t, producer.Close())
+	})
+
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		encoding string
+	}{
+		{name: "unknown precision", target: "/write?precision=d", body: "cpu usage=1\n"},
+		{name: "unknown encoding", target: "/write", body: "cpu usage=1\n", encoding: "br"},
+		{name: "malformed line", target: "/write", body: "cpu usage\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, producer := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "metrics"}})
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, bytes.NewBufferString(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			w := serveTestInfluxWrite(g, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.NoError(t, producer.Close())
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote/otlptranslator/prometheusremotewrite"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+const (
+	otlpContentTypeProtobuf = "application/x-protobuf"
+	otlpContentTypeJSON     = "application/json"
+)
+
+// otlpWriteHandler accepts OTLP/HTTP metrics export requests encoded as protobuf or JSON. Metrics are translated
+// the same way Prometheus translates them on its OTLP endpoint. Metrics which cannot be translated are reported
+// to the client as a partial success.
+func (g *Gateway) otlpWriteHandler(c *gin.Context) {
+	started := time.Now()
+
+	metricWriteBatchesRequests.Inc()
+
+	authenticatedUser := c.MustGet("user").(*User)
+
+	contentType := strings.TrimSpace(strings.SplitN(c.GetHeader("Content-Type"), ";", 2)[0])
+	if contentType != otlpContentTypeProtobuf && contentType != otlpContentTypeJSON {
+		c.String(http.StatusUnsupportedMediaType, "unsupported Content-Type: %s", c.GetHeader("Content-Type"))
+		return
+	}
+
+	body, ok := readRequestBody(c)
+	if !ok {
+		return
+	}
+
+	body, ok = decodeGzipRequestBody(c, body)
+	if !ok {
+		return
+	}
+
+	exportRequest := pmetricotlp.NewExportRequest()
+
+	var err error
+	if contentType == otlpContentTypeJSON {
+		err = exportRequest.UnmarshalJSON(body)
+	} else {
+		err = exportRequest.UnmarshalProto(body)
+	}
+
+	if err != nil {
+		c.String(http.StatusBadRequest, "error unmarshaling otlp request: %v", err)
+		return
+	}
+
+	req, translateErr := g.translateOTLPMetrics(c.Request.Context(), exportRequest.Metrics())
+	if translateErr != nil {
+		log.Printf("error translating otlp metrics of user %q: %v", authenticatedUser.Login, translateErr)
+	}
+
+	if _, ok := g.publishWriteRequest(c, authenticatedUser, "otlp", started, req); !ok {
+		return
+	}
+
+	exportResponse := pmetricotlp.NewExportResponse()
+	if translateErr != nil {
+		exportResponse.PartialSuccess().SetErrorMessage(translateErr.Error())
+	}
+
+	var data []byte
+	if contentType == otlpContentTypeJSON {
+		data, err = exportResponse.MarshalJSON()
+	} else {
+		data, err = exportResponse.MarshalProto()
+	}
+
+	if err != nil {
+		c.String(http.StatusInternalServerError, "error marshaling otlp response: %v", err)
+		return
+	}
+
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// translateOTLPMetrics converts metrics to series and metadata. The error lists metrics which cannot
+// be translated, the rest of the metrics are converted anyway.
+func (g *Gateway) translateOTLPMetrics(ctx context.Context, metrics pmetric.Metrics) (*prompb.WriteRequest, error) {
+	converter := prometheusremotewrite.NewPrometheusConverter()
+
+	_, err := converter.FromMetrics(ctx, metrics, prometheusremotewrite.Settings{
+		AddMetricSuffixes:                 true,
+		PromoteResourceAttributes:         g.config.OTLP.PromoteResourceAttributes,
+		KeepIdentifyingResourceAttributes: g.config.OTLP.KeepIdentifyingResourceAttributes,
+		ConvertHistogramsToNHCB:           g.config.OTLP.ConvertHistogramsToNHCB,
+		AllowDeltaTemporality:             g.config.OTLP.AllowDeltaTemporality,
+	})
+
+	return &prompb.WriteRequest{
+		Timeseries: converter.TimeSeries(),
+		Metadata:   converter.Metadata(),
+	}, err
+}
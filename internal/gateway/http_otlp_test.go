@@ -0,0 +1,211 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+// newTestOTLPMetrics returns a metric of every type, with the sum of the given temporality.
+func newTestOTLPMetrics(temporality pmetric.AggregationTemporality) pmetric.Metrics {
+	timestamp := pcommon.NewTimestampFromTime(time.UnixMilli(1_700_000_000_000))
+
+	metrics := pmetric.NewMetrics()
+
+	resource := metrics.ResourceMetrics().AppendEmpty()
+	resource.Resource().Attributes().PutStr("service.name", "checkout")
+	resource.Resource().Attributes().PutStr("deployment.environment", "prod")
+
+	scope := resource.ScopeMetrics().AppendEmpty()
+
+	gauge := scope.Metrics().AppendEmpty()
+	gauge.SetName("process.memory.usage")
+	gauge.SetUnit("By")
+	gaugePoint := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+	gaugePoint.SetTimestamp(timestamp)
+	gaugePoint.SetIntValue(1024)
+
+	sum := scope.Metrics().AppendEmpty()
+	sum.SetName("http.server.requests")
+	sum.SetEmptySum().SetIsMonotonic(true)
+	sum.Sum().SetAggregationTemporality(temporality)
+	sumPoint := sum.Sum().DataPoints().AppendEmpty()
+	sumPoint.SetTimestamp(timestamp)
+	sumPoint.SetDoubleValue(5)
+	sumPoint.Attributes().PutStr("http.method", "GET")
+
+	histogram := scope.Metrics().AppendEmpty()
+	histogram.SetName("http.server.duration")
+	histogram.SetUnit("s")
+	histogram.SetEmptyHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	histogramPoint := histogram.Histogram().DataPoints().AppendEmpty()
+	histogramPoint.SetTimestamp(timestamp)
+	histogramPoint.SetCount(3)
+	histogramPoint.SetSum(1.5)
+	histogramPoint.ExplicitBounds().FromRaw([]float64{0.5, 1})
+	histogramPoint.BucketCounts().FromRaw([]uint64{1, 1, 1})
+
+	exponential := scope.Metrics().AppendEmpty()
+	exponential.SetName("rpc.duration")
+	exponential.SetEmptyExponentialHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	exponentialPoint := exponential.ExponentialHistogram().DataPoints().AppendEmpty()
+	exponentialPoint.SetTimestamp(timestamp)
+	exponentialPoint.SetScale(0)
+	exponentialPoint.SetCount(2)
+	exponentialPoint.SetSum(3)
+	exponentialPoint.Positive().SetOffset(0)
+	exponentialPoint.Positive().BucketCounts().FromRaw([]uint64{1, 1})
+
+	summary := scope.Metrics().AppendEmpty()
+	summary.SetName("gc.pause")
+	summaryPoint := summary.SetEmptySummary().DataPoints().AppendEmpty()
+	summaryPoint.SetTimestamp(timestamp)
+	summaryPoint.SetCount(4)
+	summaryPoint.SetSum(2)
+	quantile := summaryPoint.QuantileValues().AppendEmpty()
+	quantile.SetQuantile(0.5)
+	quantile.SetValue(0.4)
+
+	return metrics
+}
+
+func getTestSeriesNames(req *prompb.WriteRequest) []string {
+	var names []string
+
+	for _, ts := range req.Timeseries {
+		names = append(names, getMetricName(ts.Labels))
+	}
+
+	slices.Sort(names)
+
+	return slices.Compact(names)
+}
+
+func TestTranslateOTLPMetrics(t *testing.T) {
+	t.Run("cumulative", func(t *testing.T) {
+		g := &Gateway{config: &Config{OTLP: OTLPConfig{PromoteResourceAttributes: []string{"deployment.environment"}}}}
+
+		req, err := g.translateOTLPMetrics(context.Background(), newTestOTLPMetrics(pmetric.AggregationTemporalityCumulative))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"gc_pause",
+			"gc_pause_count",
+			"gc_pause_sum",
+			"http_server_duration_seconds_bucket",
+			"http_server_duration_seconds_count",
+			"http_server_duration_seconds_sum",
+			"http_server_requests_total",
+			"process_memory_usage_bytes",
+			"rpc_duration",
+			"target_info",
+		}, getTestSeriesNames(req))
+
+		for _, ts := range req.Timeseries {
+			if getMetricName(ts.Labels) == "http_server_requests_total" {
+				assert.Contains(t, ts.Labels, prompb.Label{Name: "job", Value: "checkout"})
+				assert.Contains(t, ts.Labels, prompb.Label{Name: "deployment_environment", Value: "prod"})
+				assert.Contains(t, ts.Labels, prompb.Label{Name: "http_method", Value: "GET"})
+				assert.Equal(t, []prompb.Sample{{Value: 5, Timestamp: 1_700_000_000_000}}, ts.Samples)
+			}
+
+			if getMetricName(ts.Labels) == "rpc_duration" {
+				assert.Len(t, ts.Histograms, 1)
+			}
+		}
+
+		assert.NotEmpty(t, req.Metadata)
+	})
+
+	t.Run("delta rejected", func(t *testing.T) {
+		g := &Gateway{config: &Config{}}
+
+		req, err := g.translateOTLPMetrics(context.Background(), newTestOTLPMetrics(pmetric.AggregationTemporalityDelta))
+		require.Error(t, err)
+
+		assert.NotContains(t, getTestSeriesNames(req), "http_server_requests_total")
+		assert.Contains(t, getTestSeriesNames(req), "process_memory_usage_bytes")
+	})
+
+	t.Run("delta allowed", func(t *testing.T) {
+		g := &Gateway{config: &Config{OTLP: OTLPConfig{AllowDeltaTemporality: true}}}
+
+		req, err := g.translateOTLPMetrics(context.Background(), newTestOTLPMetrics(pmetric.AggregationTemporalityDelta))
+		require.NoError(t, err)
+
+		assert.Contains(t, getTestSeriesNames(req), "http_server_requests_total")
+	})
+}
+
+func serveTestOTLPWrite(g *Gateway, req *http.Request) *httptest.ResponseRecorder {
+	return serveTestRoute("/v1/metrics", req, g.basicAuthMiddleware(), tenantMiddleware, g.otlpWriteHandler)
+}
+
+func TestOTLPWriteHandler(t *testing.T) {
+	exportRequest := pmetricotlp.NewExportRequestFromMetrics(newTestOTLPMetrics(pmetric.AggregationTemporalityDelta))
+
+	protoBody, err := exportRequest.MarshalProto()
+	require.NoError(t, err)
+
+	jsonBody, err := exportRequest.MarshalJSON()
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{contentType: "application/x-protobuf", body: protoBody},
+		{contentType: "application/json", body: jsonBody},
+	} {
+		t.Run(tt.contentType, func(t *testing.T) {
+			g, producer := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "metrics"}})
+
+			translated, _ := g.translateOTLPMetrics(context.Background(), exportRequest.Metrics())
+			for range len(translated.Timeseries) + len(translated.Metadata) {
+				producer.ExpectInputAndSucceed()
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			w := serveTestOTLPWrite(g, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+
+			exportResponse := pmetricotlp.NewExportResponse()
+			if tt.contentType == "application/json" {
+				require.NoError(t, exportResponse.UnmarshalJSON(w.Body.Bytes()))
+			} else {
+				require.NoError(t, exportResponse.UnmarshalProto(w.Body.Bytes()))
+			}
+
+			// the delta sum is rejected
+			assert.Contains(t, exportResponse.PartialSuccess().ErrorMessage(), "http.server.requests")
+			assert.NoError(t, producer.Close())
+		})
+	}
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		g, producer := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "metrics"}})
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(protoBody))
+		req.Header.Set("Content-Type", "text/plain")
+
+		w := serveTestOTLPWrite(g, req)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+		assert.NoError(t, producer.Close())
+	})
+}
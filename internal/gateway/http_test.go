@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartListenersFailure(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	graphiteAddr := tcp.Addr().String()
+	require.NoError(t, tcp.Close())
+
+	// the statsd listener fails to bind the address in use
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer udp.Close()
+
+	graphite := &GraphiteConfig{Name: "graphite", ListenTCP: graphiteAddr}
+	graphite.setDefaults()
+
+	statsd := &StatsDConfig{Name: "statsd", ListenUDP: udp.LocalAddr().String()}
+	statsd.setDefaults()
+
+	g, _ := newTestGateway(t, &Config{
+		Kafka:    KafkaConfig{Topic: "metrics"},
+		Graphite: []*GraphiteConfig{graphite},
+		StatsD:   []*StatsDConfig{statsd},
+	})
+
+	_, err = g.startListeners()
+	require.Error(t, err)
+
+	// the graphite listener started before is closed
+	tcp, err = net.Listen("tcp", graphiteAddr)
+	require.NoError(t, err)
+	require.NoError(t, tcp.Close())
+}
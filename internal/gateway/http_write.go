@@ -1,20 +1,25 @@
 package gateway
 
 import (
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/IBM/sarama"
 	"github.com/gin-gonic/gin"
 	"github.com/gogo/protobuf/proto"
 	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
 )
 
-func getKafkaKey(labels []prompb.Label) string {
+func getMetricName(labels []prompb.Label) string {
 	for _, label := range labels {
 		//  __name__ is a special label that contains the metric name
 		if label.Name == "__name__" {
@@ -22,6 +27,14 @@ func getKafkaKey(labels []prompb.Label) string {
 		}
 	}
 
+	return ""
+}
+
+func getKafkaKey(labels []prompb.Label) string {
+	if name := getMetricName(labels); name != "" {
+		return name
+	}
+
 	hash := fnv.New64a()
 	for _, label := range labels {
 		hash.Write([]byte(label.Name))
@@ -38,8 +51,16 @@ func writeHeadersMiddleware(c *gin.Context) {
 		return
 	}
 
-	if c.GetHeader("Content-Type") != "application/x-protobuf" {
-		c.String(http.StatusBadRequest, "unsupported Content-Type: %s", c.GetHeader("Content-Type"))
+	contentType := c.GetHeader("Content-Type")
+	if strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]) != "application/x-protobuf" {
+		c.String(http.StatusBadRequest, "unsupported Content-Type: %s", contentType)
+		c.Abort()
+		return
+	}
+
+	protoMsg, err := parseProtoMsg(contentType)
+	if err != nil {
+		c.String(http.StatusUnsupportedMediaType, "%v", err)
 		c.Abort()
 		return
 	}
@@ -48,7 +69,7 @@ func writeHeadersMiddleware(c *gin.Context) {
 
 	// Prometheus Remote Write protocol
 	if value := c.GetHeader("X-Prometheus-Remote-Write-Version"); value != "" {
-		if value != "0.1.0" {
+		if !slices.Contains([]string{"0.1.0", "2.0.0"}, value) {
 			c.String(http.StatusBadRequest, "unsupported X-Prometheus-Remote-Write-Version: %s", value)
 			c.Abort()
 			return
@@ -61,6 +82,9 @@ func writeHeadersMiddleware(c *gin.Context) {
 		}
 
 		writeProtocol = "prometheus"
+		if protoMsg == protoMsgV2 {
+			writeProtocol = "prometheus_v2"
+		}
 	}
 
 	// VictoriaMetrics remote write protocol
@@ -77,6 +101,12 @@ func writeHeadersMiddleware(c *gin.Context) {
 			return
 		}
 
+		if protoMsg != protoMsgV1 {
+			c.String(http.StatusUnsupportedMediaType, "unsupported protobuf message for VictoriaMetrics: %s", protoMsg)
+			c.Abort()
+			return
+		}
+
 		writeProtocol = "victoriametrics"
 	}
 
@@ -86,36 +116,102 @@ func writeHeadersMiddleware(c *gin.Context) {
 		return
 	}
 
-	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxInsertRequestSize)
+	c.Set("writeProtocol", writeProtocol)
+	c.Set("protoMsg", protoMsg)
 
 	c.Next()
 }
 
-func (g *Gateway) writeHandler(c *gin.Context) {
-	if !g.lastErrorTime.IsZero() && g.lastErrorTime.Unix() >= time.Now().Add(-10*time.Second).Unix() {
-		c.String(http.StatusServiceUnavailable, "gateway is in error state")
-		return
+// readRequestBody reads the body of the write request up to maxInsertRequestSize. It answers the client
+// and returns false when the body cannot be read.
+func readRequestBody(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxInsertRequestSize))
+	if err != nil {
+		if strings.Contains(err.Error(), "request too large") {
+			c.String(http.StatusRequestEntityTooLarge, "request body is too large")
+		} else {
+			c.String(http.StatusInternalServerError, "error reading request body: %v", err)
+		}
+		return nil, false
+	}
+
+	metricWriteBatchesReceivedBytes.Add(float64(len(body)))
+
+	return body, true
+}
+
+// decodeGzipRequestBody decompresses the body of text and JSON write requests, which are sent plain or
+// with gzip encoding. It answers the client and returns false when the encoding is not supported.
+func decodeGzipRequestBody(c *gin.Context, body []byte) ([]byte, bool) {
+	switch c.GetHeader("Content-Encoding") {
+	case "", "identity":
+
+	case "gzip":
+		decompressed, err := decompressGzip(body)
+		if err != nil {
+			c.String(http.StatusBadRequest, "error decoding gzip: %v", err)
+			return nil, false
+		}
+
+		body = decompressed
+
+		metricWriteBatchesRequestsEncoding.WithLabelValues("gzip").Inc()
+
+	default:
+		c.String(http.StatusBadRequest, "unsupported Content-Encoding: %s", c.GetHeader("Content-Encoding"))
+		return nil, false
 	}
 
+	metricWriteBatchesReceivedUncompressedBytes.Add(float64(len(body)))
+
+	return body, true
+}
+
+// publishWriteRequest produces series of the write request received with the protocol and returns indexes
+// of the series dropped by relabeling. It answers the client and returns false when the request cannot be published.
+func (g *Gateway) publishWriteRequest(c *gin.Context, user *User, protocol string, started time.Time, req *prompb.WriteRequest) ([]int, bool) {
+	messages, dropped, err := g.buildMessages(user, envelope.Envelope{
+		Tenant:     c.GetString("tenant"),
+		User:       user.Login,
+		Protocol:   protocol,
+		ReceivedAt: started,
+	}, req)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return nil, false
+	}
+
+	if err := g.publish(messages); err != nil {
+		var openErr *circuitOpenError
+		if errors.As(err, &openErr) {
+			c.Header("Retry-After", strconv.Itoa(openErr.retryAfterSeconds()))
+		}
+
+		c.String(http.StatusServiceUnavailable, "error writing to kafka: %v", err)
+		return nil, false
+	}
+
+	metricsWriteBatchesRequestsDuration.Observe(time.Since(started).Seconds())
+
+	return dropped, true
+}
+
+func (g *Gateway) writeHandler(c *gin.Context) {
 	started := time.Now()
 
 	metricWriteBatchesRequests.Inc()
 
 	authenticatedUser := c.MustGet("user").(*User)
 
-	compressed, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		if strings.Contains(err.Error(), "request too large") {
-			c.String(http.StatusRequestEntityTooLarge, "request body is too large")
-		} else {
-			c.String(http.StatusInternalServerError, "error reading request body: %v", err)
-		}
+	compressed, ok := readRequestBody(c)
+	if !ok {
 		return
 	}
 
-	metricWriteBatchesReceivedBytes.Add(float64(len(compressed)))
-
-	var requestBuffer []byte
+	var (
+		requestBuffer []byte
+		err           error
+	)
 
 	switch c.GetHeader("Content-Encoding") {
 	case "snappy":
@@ -143,56 +239,42 @@ func (g *Gateway) writeHandler(c *gin.Context) {
 
 	metricWriteBatchesReceivedUncompressedBytes.Add(float64(len(requestBuffer)))
 
-	var req prompb.WriteRequest
-	if err := proto.Unmarshal(requestBuffer, &req); err != nil {
+	req, stats, err := unmarshalWriteRequest(c.GetString("protoMsg"), requestBuffer)
+	if err != nil {
 		c.String(http.StatusBadRequest, "error unmarshaling protobuf: %v", err)
 		return
 	}
 
-	kafkaTopic := g.config.Kafka.Topic
-	if authenticatedUser.Topic != nil {
-		kafkaTopic = *authenticatedUser.Topic
+	dropped, ok := g.publishWriteRequest(c, authenticatedUser, c.GetString("writeProtocol"), started, req)
+	if !ok {
+		return
 	}
 
-	for _, ts := range req.GetTimeseries() {
-		// reconstruct the original TimeSeries
-		messgaeWriteRequest := &prompb.TimeSeries{
-			Labels:     ts.Labels,
-			Exemplars:  ts.Exemplars,
-			Samples:    ts.Samples,
-			Histograms: ts.Histograms,
-		}
+	if c.GetString("protoMsg") == protoMsgV2 {
+		stats.written(dropped).setHeaders(c)
+	}
 
-		messageBytes, err := proto.Marshal(messgaeWriteRequest)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "error marshaling protobuf: %v", err)
-			return
-		}
+	c.Status(http.StatusNoContent)
+}
 
-		message := &sarama.ProducerMessage{
-			Topic: kafkaTopic,
-			Key:   sarama.StringEncoder(getKafkaKey(ts.Labels)),
-			Value: sarama.ByteEncoder(messageBytes),
+func unmarshalWriteRequest(protoMsg string, data []byte) (*prompb.WriteRequest, seriesWriteStats, error) {
+	if protoMsg == protoMsgV2 {
+		var req writev2.Request
+		if err := proto.Unmarshal(data, &req); err != nil {
+			return nil, nil, err
 		}
 
-		metricWriteKafkaMessages.WithLabelValues(kafkaTopic).Inc()
-
-		select {
-		case g.kafkaProducer.Input() <- message:
-			continue
+		metricWriteBatchesRequestsProtoMsg.WithLabelValues(protoMsgV2).Inc()
 
-		case <-time.After(g.getKafkaWriteTimeout()):
-			c.String(http.StatusServiceUnavailable, "timeout writing to kafka")
-		}
+		return convertWriteV2Request(&req)
 	}
 
-	metricsWriteBatchesRequestsDuration.Observe(time.Since(started).Seconds())
-
-	c.Status(http.StatusNoContent)
-}
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(data, &req); err != nil {
+		return nil, nil, err
+	}
 
-func (g *Gateway) getKafkaWriteTimeout() time.Duration {
-	config := g.kafkaClient.Config()
+	metricWriteBatchesRequestsProtoMsg.WithLabelValues(protoMsgV1).Inc()
 
-	return config.Producer.Timeout * time.Duration(config.Producer.Retry.Max)
+	return &req, nil, nil
 }
@@ -5,10 +5,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/IBM/sarama"
 	"github.com/gin-gonic/gin"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
 )
 
 func TestGetKafkaKey(t *testing.T) {
@@ -118,6 +124,33 @@ func TestWriteHeadersMiddleware(t *testing.T) {
 			},
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name: "valid headers with Remote Write 2.0",
+			headers: map[string]string{
+				"Content-Encoding":                  "snappy",
+				"Content-Type":                      "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+				"X-Prometheus-Remote-Write-Version": "2.0.0",
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name: "unsupported proto message",
+			headers: map[string]string{
+				"Content-Encoding":                  "snappy",
+				"Content-Type":                      "application/x-protobuf;proto=io.prometheus.write.v3.Request",
+				"X-Prometheus-Remote-Write-Version": "2.0.0",
+			},
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "Remote Write 2.0 with VictoriaMetrics protocol",
+			headers: map[string]string{
+				"Content-Encoding":                       "zstd",
+				"Content-Type":                           "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+				"X-VictoriaMetrics-Remote-Write-Version": "1",
+			},
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
 		{
 			name: "valid headers with X-Prometheus-Remote-Write-Version",
 			headers: map[string]string{
@@ -218,3 +251,119 @@ func TestWriteHeadersMiddleware(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
+
+func newTestWriteRequest(t *testing.T, req *prompb.WriteRequest) *http.Request {
+	t.Helper()
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to marshal write request: %v", err)
+	}
+
+	httpReq := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, data)))
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	return httpReq
+}
+
+func parseTestEnvelope(t *testing.T, msg *sarama.ProducerMessage) envelope.Envelope {
+	t.Helper()
+
+	headers := make([]*sarama.RecordHeader, 0, len(msg.Headers))
+	for idx := range msg.Headers {
+		headers = append(headers, &msg.Headers[idx])
+	}
+
+	meta, err := envelope.Parse(headers)
+	if err != nil {
+		t.Fatalf("failed to parse envelope: %v", err)
+	}
+
+	return meta
+}
+
+func serveTestWrite(g *Gateway, req *http.Request) *httptest.ResponseRecorder {
+	return serveTestRoute("/api/v1/write", req, g.basicAuthMiddleware(), tenantMiddleware, writeHeadersMiddleware, g.writeHandler)
+}
+
+func TestWriteHandler(t *testing.T) {
+	writeRequest := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			},
+		},
+		Metadata: []prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up", Help: "Target is up"},
+		},
+	}
+
+	t.Run("series and metadata are produced", func(t *testing.T) {
+		g, producer := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "metrics", MetadataTopic: "metadata"}})
+
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			meta := parseTestEnvelope(t, msg)
+
+			assert.Equal(t, "metrics", msg.Topic)
+			assert.Equal(t, envelope.KindTimeSeries, meta.Kind)
+			assert.Equal(t, "prometheus", meta.Protocol)
+			assert.Equal(t, envelope.SchemaVersion, meta.SchemaVersion)
+			assert.WithinDuration(t, time.Now(), meta.ReceivedAt, time.Minute)
+			return nil
+		})
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			assert.Equal(t, "metadata", msg.Topic)
+			assert.Equal(t, envelope.KindMetadata, parseTestEnvelope(t, msg).Kind)
+			return nil
+		})
+
+		w := serveTestWrite(g, newTestWriteRequest(t, writeRequest))
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.NoError(t, producer.Close())
+	})
+
+	t.Run("sync ack succeeded", func(t *testing.T) {
+		g, producer := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "metrics", SyncAck: true}})
+
+		producer.ExpectInputAndSucceed()
+		producer.ExpectInputAndSucceed()
+
+		w := serveTestWrite(g, newTestWriteRequest(t, writeRequest))
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.NoError(t, producer.Close())
+	})
+
+	t.Run("sync ack failed", func(t *testing.T) {
+		g, producer := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "metrics", SyncAck: true}})
+
+		producer.ExpectInputAndSucceed()
+		producer.ExpectInputAndFail(sarama.ErrNotEnoughReplicas)
+
+		w := serveTestWrite(g, newTestWriteRequest(t, writeRequest))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.NoError(t, producer.Close())
+	})
+
+	t.Run("sync ack failed with spool", func(t *testing.T) {
+		g, producer := newTestGateway(t, &Config{
+			Kafka: KafkaConfig{Topic: "metrics", SyncAck: true},
+			Spool: SpoolConfig{Path: t.TempDir()},
+		})
+
+		producer.ExpectInputAndSucceed()
+		producer.ExpectInputAndFail(sarama.ErrNotEnoughReplicas)
+
+		w := serveTestWrite(g, newTestWriteRequest(t, writeRequest))
+
+		// the failed message is spooled, so the client must not retry the request
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Positive(t, g.spool.totalSize)
+		assert.NoError(t, producer.Close())
+	})
+}
@@ -0,0 +1,245 @@
+package gateway
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+const (
+	protoMsgV1 = "prometheus.WriteRequest"
+	protoMsgV2 = "io.prometheus.write.v2.Request"
+
+	rw20WrittenSamplesHeader    = "X-Prometheus-Remote-Write-Samples-Written"
+	rw20WrittenHistogramsHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	rw20WrittenExemplarsHeader  = "X-Prometheus-Remote-Write-Exemplars-Written"
+)
+
+type writeStats struct {
+	Samples    int
+	Histograms int
+	Exemplars  int
+}
+
+// seriesWriteStats are stats of every series of the request.
+type seriesWriteStats []writeStats
+
+// written returns stats of the series except the ones dropped by relabeling.
+func (s seriesWriteStats) written(dropped []int) writeStats {
+	var stats writeStats
+
+	for idx, series := range s {
+		if len(dropped) > 0 && dropped[0] == idx {
+			dropped = dropped[1:]
+			continue
+		}
+
+		stats.Samples += series.Samples
+		stats.Histograms += series.Histograms
+		stats.Exemplars += series.Exemplars
+	}
+
+	return stats
+}
+
+func (s writeStats) setHeaders(c *gin.Context) {
+	c.Header(rw20WrittenSamplesHeader, strconv.Itoa(s.Samples))
+	c.Header(rw20WrittenHistogramsHeader, strconv.Itoa(s.Histograms))
+	c.Header(rw20WrittenExemplarsHeader, strconv.Itoa(s.Exemplars))
+}
+
+// parseProtoMsg returns the protobuf message from the "proto" parameter of the Content-Type header.
+// When the parameter is absent, Remote Write 1.0 message is assumed.
+func parseProtoMsg(contentType string) (string, error) {
+	parts := strings.Split(contentType, ";")
+
+	for _, param := range parts[1:] {
+		pair := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(pair) != 2 {
+			return "", fmt.Errorf("malformed Content-Type parameter: %s", param)
+		}
+
+		if pair[0] != "proto" {
+			continue
+		}
+
+		switch pair[1] {
+		case protoMsgV1, protoMsgV2:
+			return pair[1], nil
+
+		default:
+			return "", fmt.Errorf("unsupported protobuf message: %s", pair[1])
+		}
+	}
+
+	return protoMsgV1, nil
+}
+
+// convertWriteV2Request converts Remote Write 2.0 request to the 1.0 one, so it can be published
+// in the same format the worker consumes. Created timestamp zero samples are not counted in stats of the series.
+func convertWriteV2Request(req *writev2.Request) (*prompb.WriteRequest, seriesWriteStats, error) {
+	stats := make(seriesWriteStats, 0, len(req.Timeseries))
+
+	symbols := req.GetSymbols()
+	if len(symbols) == 0 || symbols[0] != "" {
+		return nil, nil, fmt.Errorf("symbols table must start with an empty string")
+	}
+
+	result := &prompb.WriteRequest{
+		Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries)),
+	}
+
+	metadataSeen := make(map[string]struct{})
+
+	for _, ts := range req.Timeseries {
+		labels, err := desymbolizeLabels(ts.LabelsRefs, symbols)
+		if err != nil {
+			return nil, nil, fmt.Errorf("series labels: %w", err)
+		}
+
+		series := prompb.TimeSeries{
+			Labels:     labels,
+			Samples:    make([]prompb.Sample, 0, len(ts.Samples)+1),
+			Histograms: make([]prompb.Histogram, 0, len(ts.Histograms)+1),
+		}
+
+		if ts.CreatedTimestamp != 0 && len(ts.Samples) > 0 && ts.CreatedTimestamp < ts.Samples[0].Timestamp {
+			// ingest created timestamp as a zero sample, the same way Prometheus does
+			series.Samples = append(series.Samples, prompb.Sample{Timestamp: ts.CreatedTimestamp})
+		}
+
+		for _, sample := range ts.Samples {
+			series.Samples = append(series.Samples, prompb.Sample{Value: sample.Value, Timestamp: sample.Timestamp})
+		}
+
+		if ts.CreatedTimestamp != 0 && len(ts.Histograms) > 0 && ts.CreatedTimestamp < ts.Histograms[0].Timestamp {
+			series.Histograms = append(series.Histograms, zeroHistogram(ts.Histograms[0], ts.CreatedTimestamp))
+		}
+
+		for _, hist := range ts.Histograms {
+			if hist.IsFloatHistogram() {
+				series.Histograms = append(series.Histograms, prompb.FromFloatHistogram(hist.Timestamp, hist.ToFloatHistogram()))
+			} else {
+				series.Histograms = append(series.Histograms, prompb.FromIntHistogram(hist.Timestamp, hist.ToIntHistogram()))
+			}
+		}
+
+		for _, ex := range ts.Exemplars {
+			exemplarLabels, err := desymbolizeLabels(ex.LabelsRefs, symbols)
+			if err != nil {
+				return nil, nil, fmt.Errorf("exemplar labels: %w", err)
+			}
+
+			series.Exemplars = append(series.Exemplars, prompb.Exemplar{
+				Labels:    exemplarLabels,
+				Value:     ex.Value,
+				Timestamp: ex.Timestamp,
+			})
+		}
+
+		metadata, err := convertMetadataV2(ts.Metadata, labels, symbols)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if metadata != nil {
+			if _, ok := metadataSeen[metadata.MetricFamilyName]; !ok {
+				metadataSeen[metadata.MetricFamilyName] = struct{}{}
+				result.Metadata = append(result.Metadata, *metadata)
+			}
+		}
+
+		stats = append(stats, writeStats{
+			Samples:    len(ts.Samples),
+			Histograms: len(ts.Histograms),
+			Exemplars:  len(ts.Exemplars),
+		})
+
+		result.Timeseries = append(result.Timeseries, series)
+	}
+
+	return result, stats, nil
+}
+
+func desymbolizeLabels(refs []uint32, symbols []string) ([]prompb.Label, error) {
+	if len(refs)%2 != 0 {
+		return nil, fmt.Errorf("odd number of label references: %d", len(refs))
+	}
+
+	labels := make([]prompb.Label, 0, len(refs)/2)
+
+	for i := 0; i < len(refs); i += 2 {
+		if int(refs[i]) >= len(symbols) || int(refs[i+1]) >= len(symbols) {
+			return nil, fmt.Errorf("label reference out of symbols table range: %d", len(symbols))
+		}
+
+		labels = append(labels, prompb.Label{
+			Name:  symbols[refs[i]],
+			Value: symbols[refs[i+1]],
+		})
+	}
+
+	slices.SortFunc(labels, func(a, b prompb.Label) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return labels, nil
+}
+
+// convertMetadataV2 returns metric family metadata of the series or nil if it was not sent.
+func convertMetadataV2(metadata writev2.Metadata, labels []prompb.Label, symbols []string) (*prompb.MetricMetadata, error) {
+	if int(metadata.HelpRef) >= len(symbols) || int(metadata.UnitRef) >= len(symbols) {
+		return nil, fmt.Errorf("metadata reference out of symbols table range: %d", len(symbols))
+	}
+
+	if metadata.Type == writev2.Metadata_METRIC_TYPE_UNSPECIFIED && metadata.HelpRef == 0 && metadata.UnitRef == 0 {
+		return nil, nil
+	}
+
+	name := getMetricName(labels)
+	if name == "" {
+		return nil, nil
+	}
+
+	switch metadata.Type {
+	case writev2.Metadata_METRIC_TYPE_HISTOGRAM, writev2.Metadata_METRIC_TYPE_GAUGEHISTOGRAM, writev2.Metadata_METRIC_TYPE_SUMMARY:
+		// classic histograms and summaries are sent as several series of the same family
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if trimmed, ok := strings.CutSuffix(name, suffix); ok {
+				name = trimmed
+				break
+			}
+		}
+	}
+
+	return &prompb.MetricMetadata{
+		// metric types of both protocol versions share the same enum values
+		Type:             prompb.MetricMetadata_MetricType(metadata.Type),
+		MetricFamilyName: name,
+		Help:             symbols[metadata.HelpRef],
+		Unit:             symbols[metadata.UnitRef],
+	}, nil
+}
+
+// zeroHistogram returns an empty histogram with the layout of the given one, used for created timestamps.
+func zeroHistogram(hist writev2.Histogram, timestamp int64) prompb.Histogram {
+	if hist.IsFloatHistogram() {
+		return prompb.FromFloatHistogram(timestamp, &histogram.FloatHistogram{
+			Schema:        hist.Schema,
+			ZeroThreshold: hist.ZeroThreshold,
+			CustomValues:  hist.CustomValues,
+		})
+	}
+
+	return prompb.FromIntHistogram(timestamp, &histogram.Histogram{
+		Schema:        hist.Schema,
+		ZeroThreshold: hist.ZeroThreshold,
+		CustomValues:  hist.CustomValues,
+	})
+}
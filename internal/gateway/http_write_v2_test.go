@@ -0,0 +1,193 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
+)
+
+func TestParseProtoMsg(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:        "no proto parameter",
+			contentType: "application/x-protobuf",
+			want:        protoMsgV1,
+		},
+		{
+			name:        "remote write 1.0",
+			contentType: "application/x-protobuf;proto=prometheus.WriteRequest",
+			want:        protoMsgV1,
+		},
+		{
+			name:        "remote write 2.0",
+			contentType: "application/x-protobuf; proto=io.prometheus.write.v2.Request",
+			want:        protoMsgV2,
+		},
+		{
+			name:        "unknown proto message",
+			contentType: "application/x-protobuf;proto=unknown.Request",
+			wantErr:     true,
+		},
+		{
+			name:        "malformed parameter",
+			contentType: "application/x-protobuf;proto",
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProtoMsg(tt.contentType)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConvertWriteV2Request(t *testing.T) {
+	t.Run("series with metadata and created timestamp", func(t *testing.T) {
+		req := &writev2.Request{
+			Symbols: []string{"", "__name__", "http_requests_total", "job", "api", "Total requests", "trace_id", "abc"},
+			Timeseries: []writev2.TimeSeries{
+				{
+					LabelsRefs: []uint32{3, 4, 1, 2},
+					Samples:    []writev2.Sample{{Value: 10, Timestamp: 2000}},
+					Exemplars:  []writev2.Exemplar{{LabelsRefs: []uint32{6, 7}, Value: 1, Timestamp: 1500}},
+					Metadata: writev2.Metadata{
+						Type:    writev2.Metadata_METRIC_TYPE_COUNTER,
+						HelpRef: 5,
+					},
+					CreatedTimestamp: 1000,
+				},
+			},
+		}
+
+		got, stats, err := convertWriteV2Request(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, seriesWriteStats{{Samples: 1, Exemplars: 1}}, stats)
+		require.Len(t, got.Timeseries, 1)
+
+		series := got.Timeseries[0]
+		assert.Equal(t, []prompb.Label{
+			{Name: "__name__", Value: "http_requests_total"},
+			{Name: "job", Value: "api"},
+		}, series.Labels)
+		assert.Equal(t, []prompb.Sample{{Value: 0, Timestamp: 1000}, {Value: 10, Timestamp: 2000}}, series.Samples)
+		assert.Equal(t, []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 1, Timestamp: 1500}}, series.Exemplars)
+
+		assert.Equal(t, []prompb.MetricMetadata{{
+			Type:             prompb.MetricMetadata_COUNTER,
+			MetricFamilyName: "http_requests_total",
+			Help:             "Total requests",
+		}}, got.Metadata)
+	})
+
+	t.Run("classic histogram metadata is deduplicated", func(t *testing.T) {
+		metadata := writev2.Metadata{Type: writev2.Metadata_METRIC_TYPE_HISTOGRAM, UnitRef: 4}
+
+		req := &writev2.Request{
+			Symbols: []string{"", "__name__", "latency_seconds_sum", "latency_seconds_count", "seconds"},
+			Timeseries: []writev2.TimeSeries{
+				{LabelsRefs: []uint32{1, 2}, Samples: []writev2.Sample{{Value: 1, Timestamp: 1}}, Metadata: metadata},
+				{LabelsRefs: []uint32{1, 3}, Samples: []writev2.Sample{{Value: 2, Timestamp: 1}}, Metadata: metadata},
+			},
+		}
+
+		got, stats, err := convertWriteV2Request(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, seriesWriteStats{{Samples: 1}, {Samples: 1}}, stats)
+		assert.Equal(t, []prompb.MetricMetadata{{
+			Type:             prompb.MetricMetadata_HISTOGRAM,
+			MetricFamilyName: "latency_seconds",
+			Unit:             "seconds",
+		}}, got.Metadata)
+	})
+
+	t.Run("native histogram", func(t *testing.T) {
+		req := &writev2.Request{
+			Symbols: []string{"", "__name__", "latency_seconds"},
+			Timeseries: []writev2.TimeSeries{
+				{
+					LabelsRefs: []uint32{1, 2},
+					Histograms: []writev2.Histogram{{
+						Count:     &writev2.Histogram_CountInt{CountInt: 3},
+						ZeroCount: &writev2.Histogram_ZeroCountInt{ZeroCountInt: 1},
+						Sum:       5,
+						Schema:    1,
+						Timestamp: 2000,
+					}},
+					CreatedTimestamp: 1000,
+				},
+			},
+		}
+
+		got, stats, err := convertWriteV2Request(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, seriesWriteStats{{Histograms: 1}}, stats)
+		require.Len(t, got.Timeseries[0].Histograms, 2)
+		assert.Equal(t, int64(1000), got.Timeseries[0].Histograms[0].Timestamp)
+		assert.Equal(t, uint64(0), got.Timeseries[0].Histograms[0].GetCountInt())
+		assert.Equal(t, uint64(3), got.Timeseries[0].Histograms[1].GetCountInt())
+		assert.Equal(t, 5.0, got.Timeseries[0].Histograms[1].Sum)
+	})
+
+	t.Run("invalid symbol references", func(t *testing.T) {
+		tests := []*writev2.Request{
+			{Symbols: []string{"a"}},
+			{Symbols: []string{""}, Timeseries: []writev2.TimeSeries{{LabelsRefs: []uint32{1}}}},
+			{Symbols: []string{""}, Timeseries: []writev2.TimeSeries{{LabelsRefs: []uint32{1, 2}}}},
+			{Symbols: []string{""}, Timeseries: []writev2.TimeSeries{{Metadata: writev2.Metadata{HelpRef: 7}}}},
+		}
+
+		for _, req := range tests {
+			_, _, err := convertWriteV2Request(req)
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestWrittenStats(t *testing.T) {
+	config := loadTestConfig(t, `
+kafka:
+  topic: metrics
+write_relabel_configs:
+  - source_labels: [__name__]
+    regex: go_.*
+    action: drop
+`)
+
+	g := &Gateway{config: config}
+
+	_, dropped, err := g.buildMessages(&User{}, envelope.Envelope{}, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}},
+			{Labels: []prompb.Label{{Name: "__name__", Value: "go_goroutines"}}},
+			{Labels: []prompb.Label{{Name: "__name__", Value: "latency_seconds"}}},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, dropped)
+
+	stats := seriesWriteStats{{Samples: 2, Exemplars: 1}, {Samples: 3}, {Histograms: 1}}
+
+	// samples of the series dropped by relabeling are not written
+	assert.Equal(t, writeStats{Samples: 2, Histograms: 1, Exemplars: 1}, stats.written(dropped))
+}
@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// errAckTimeout is returned when Kafka did not acknowledge every message in time.
+var errAckTimeout = errors.New("timeout waiting for kafka acknowledgement")
+
+// produceAck collects delivery reports of all messages produced for a single write request.
+// It is attached to sarama.ProducerMessage.Metadata and resolved by the producer monitors.
+type produceAck struct {
+	wg sync.WaitGroup
+
+	mu        sync.Mutex
+	err       error
+	messages  []*sarama.ProducerMessage
+	delivered map[*sarama.ProducerMessage]struct{}
+}
+
+func (a *produceAck) add(message *sarama.ProducerMessage) {
+	a.mu.Lock()
+	a.messages = append(a.messages, message)
+	a.mu.Unlock()
+
+	a.wg.Add(1)
+}
+
+// discard releases the message which was not queued to the producer.
+func (a *produceAck) discard(message *sarama.ProducerMessage) {
+	a.mu.Lock()
+	a.messages = slices.DeleteFunc(a.messages, func(m *sarama.ProducerMessage) bool { return m == message })
+	a.mu.Unlock()
+
+	a.wg.Done()
+}
+
+func (a *produceAck) done(message *sarama.ProducerMessage, err error) {
+	a.mu.Lock()
+	if err != nil {
+		if a.err == nil {
+			a.err = err
+		}
+	} else {
+		if a.delivered == nil {
+			a.delivered = make(map[*sarama.ProducerMessage]struct{})
+		}
+
+		a.delivered[message] = struct{}{}
+	}
+	a.mu.Unlock()
+
+	a.wg.Done()
+}
+
+// wait blocks until every message is acknowledged by Kafka and returns the first delivery error,
+// or errAckTimeout when some of the messages are still in flight after the timeout.
+func (a *produceAck) wait(timeout time.Duration) error {
+	finished := make(chan struct{})
+
+	go func() {
+		a.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		return a.err
+
+	case <-time.After(timeout):
+		return errAckTimeout
+	}
+}
+
+// undeliveredMessages returns messages Kafka has not confirmed yet: failed ones and, after a timeout,
+// the ones still in flight, which may be delivered later.
+func (a *produceAck) undeliveredMessages() []*sarama.ProducerMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var result []*sarama.ProducerMessage
+
+	for _, message := range a.messages {
+		if _, ok := a.delivered[message]; !ok {
+			result = append(result, message)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProduceAck(t *testing.T) {
+	t.Run("all messages delivered", func(t *testing.T) {
+		first := &sarama.ProducerMessage{Topic: "first"}
+		second := &sarama.ProducerMessage{Topic: "second"}
+
+		ack := &produceAck{}
+		ack.add(first)
+		ack.add(second)
+
+		go ack.done(first, nil)
+		go ack.done(second, nil)
+
+		assert.NoError(t, ack.wait(time.Second))
+		assert.Empty(t, ack.undeliveredMessages())
+	})
+
+	t.Run("first error is returned", func(t *testing.T) {
+		first := &sarama.ProducerMessage{Topic: "first"}
+		second := &sarama.ProducerMessage{Topic: "second"}
+		delivered := &sarama.ProducerMessage{Topic: "delivered"}
+		discarded := &sarama.ProducerMessage{Topic: "discarded"}
+
+		ack := &produceAck{}
+		ack.add(first)
+		ack.add(second)
+		ack.add(delivered)
+		ack.add(discarded)
+
+		ack.done(first, errors.New("first"))
+		ack.done(second, errors.New("second"))
+		ack.done(delivered, nil)
+		ack.discard(discarded)
+
+		assert.EqualError(t, ack.wait(time.Second), "first")
+		assert.Equal(t, []*sarama.ProducerMessage{first, second}, ack.undeliveredMessages())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		delivered := &sarama.ProducerMessage{Topic: "delivered"}
+		inFlight := &sarama.ProducerMessage{Topic: "in-flight"}
+
+		ack := &produceAck{}
+		ack.add(delivered)
+		ack.add(inFlight)
+
+		ack.done(delivered, nil)
+
+		assert.ErrorIs(t, ack.wait(10*time.Millisecond), errAckTimeout)
+		assert.Equal(t, []*sarama.ProducerMessage{inFlight}, ack.undeliveredMessages())
+
+		ack.done(inFlight, errors.New("late failure"))
+	})
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	mappingActionMap  = "map"
+	mappingActionDrop = "drop"
+)
+
+// Mapping converts the dot-separated path of Graphite and StatsD metrics to the metric name and labels.
+// Match is a glob where "*" matches a single path component, Name and label values may reference
+// the matched components as ${1}, ${2} and so on. With the drop action matching metrics are dropped.
+type Mapping struct {
+	Match  string            `yaml:"match"`
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels"`
+	Action string            `yaml:"action"`
+
+	match *regexp.Regexp
+}
+
+func (m *Mapping) compile() error {
+	if m.Match == "" {
+		return errors.New("mapping match is required")
+	}
+
+	if m.Action == "" {
+		m.Action = mappingActionMap
+	}
+
+	switch m.Action {
+	case mappingActionMap:
+		if m.Name == "" {
+			return fmt.Errorf("mapping name is required for %s", m.Match)
+		}
+
+	case mappingActionDrop:
+
+	default:
+		return fmt.Errorf("unknown mapping action: %s", m.Action)
+	}
+
+	for name := range m.Labels {
+		if !model.LabelName(name).IsValid() || name == model.MetricNameLabel {
+			return fmt.Errorf("invalid mapping label name: %s", name)
+		}
+	}
+
+	components := strings.Split(m.Match, ".")
+	for idx, component := range components {
+		components[idx] = strings.ReplaceAll(regexp.QuoteMeta(component), `\*`, `([^.]+)`)
+	}
+
+	match, err := regexp.Compile("^" + strings.Join(components, `\.`) + "$")
+	if err != nil {
+		return fmt.Errorf("invalid mapping match %s: %w", m.Match, err)
+	}
+
+	m.match = match
+
+	return nil
+}
+
+// mapPath returns the metric name and labels of the path by the first matching mapping. Paths without
+// a matching mapping keep their name with invalid characters replaced by underscores. It returns false
+// when the path is dropped.
+func mapPath(mappings []*Mapping, path string) (string, []prompb.Label, bool) {
+	for _, mapping := range mappings {
+		submatches := mapping.match.FindStringSubmatchIndex(path)
+		if submatches == nil {
+			continue
+		}
+
+		if mapping.Action == mappingActionDrop {
+			return "", nil, false
+		}
+
+		name := string(mapping.match.ExpandString(nil, mapping.Name, path, submatches))
+
+		labels := make([]prompb.Label, 0, len(mapping.Labels))
+		for labelName, template := range mapping.Labels {
+			labels = append(labels, prompb.Label{
+				Name:  labelName,
+				Value: string(mapping.match.ExpandString(nil, template, path, submatches)),
+			})
+		}
+
+		return sanitizeMetricName(name), labels, true
+	}
+
+	return sanitizeMetricName(path), nil, true
+}
+
+// buildMappedSeriesLabels returns sorted labels of the series, mapping labels override the labels
+// sent with the metric and labels with empty values are skipped.
+func buildMappedSeriesLabels(name string, sent, mapped []prompb.Label) []prompb.Label {
+	result := make([]prompb.Label, 0, len(sent)+len(mapped)+1)
+	result = append(result, prompb.Label{Name: model.MetricNameLabel, Value: name})
+
+	for _, label := range slices.Concat(mapped, sent) {
+		if label.Value == "" || slices.ContainsFunc(result, func(l prompb.Label) bool { return l.Name == label.Name }) {
+			continue
+		}
+
+		result = append(result, label)
+	}
+
+	slices.SortFunc(result, func(a, b prompb.Label) int { return strings.Compare(a.Name, b.Name) })
+
+	return result
+}
@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapPath(t *testing.T) {
+	mappings := []*Mapping{
+		{Match: "servers.*.cpu.*", Name: "server_cpu_${2}", Labels: map[string]string{"host": "${1}"}},
+		{Match: "servers.*.debug.*", Action: mappingActionDrop},
+		{Match: "app.requests", Name: "app_requests_total"},
+	}
+
+	for _, mapping := range mappings {
+		require.NoError(t, mapping.compile())
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		metric string
+		labels []prompb.Label
+		keep   bool
+	}{
+		{
+			name:   "captures",
+			path:   "servers.web-1.cpu.idle",
+			metric: "server_cpu_idle",
+			labels: []prompb.Label{{Name: "host", Value: "web-1"}},
+			keep:   true,
+		},
+		{name: "drop", path: "servers.web-1.debug.gc", keep: false},
+		{name: "literal", path: "app.requests", metric: "app_requests_total", labels: []prompb.Label{}, keep: true},
+		{name: "component count", path: "servers.web-1.cpu.idle.total", metric: "servers_web_1_cpu_idle_total", keep: true},
+		{name: "unmatched", path: "disk.sda.used", metric: "disk_sda_used", keep: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, labels, keep := mapPath(mappings, tt.path)
+
+			assert.Equal(t, tt.keep, keep)
+			assert.Equal(t, tt.metric, metric)
+			assert.Equal(t, tt.labels, labels)
+		})
+	}
+}
+
+func TestMappingValidation(t *testing.T) {
+	for name, mapping := range map[string]*Mapping{
+		"missing match":     {Name: "up"},
+		"missing name":      {Match: "a.*"},
+		"unknown action":    {Match: "a.*", Name: "a", Action: "keep"},
+		"empty label name":  {Match: "a.*", Name: "a", Labels: map[string]string{"": "${1}"}},
+		"metric name label": {Match: "a.*", Name: "a", Labels: map[string]string{"__name__": "${1}"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, mapping.compile())
+		})
+	}
+}
+
+func TestBuildMappedSeriesLabels(t *testing.T) {
+	labels := buildMappedSeriesLabels("up",
+		[]prompb.Label{{Name: "job", Value: "sent"}, {Name: "env", Value: "prod"}, {Name: "__name__", Value: "other"}},
+		[]prompb.Label{{Name: "job", Value: "mapped"}, {Name: "host", Value: ""}},
+	)
+
+	assert.Equal(t, []prompb.Label{
+		{Name: "__name__", Value: "up"},
+		{Name: "env", Value: "prod"},
+		{Name: "job", Value: "mapped"},
+	}, labels)
+}
@@ -46,6 +46,21 @@ var (
 		},
 		[]string{"encoding"},
 	)
+	metricWriteBatchesRequestsProtoMsg = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "write_batches_requests_proto_msg_total",
+		},
+		[]string{"proto_msg"},
+	)
+	metricWriteMetadata = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "write_metadata_total",
+		},
+	)
 	metricWriteKafkaMessages = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: metricNamespace,
@@ -54,6 +69,217 @@ var (
 		},
 		[]string{"topic"},
 	)
+	metricWriteKafkaAckFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "write_kafka_ack_failures_total",
+			Help:      "Write requests failed because Kafka did not acknowledge their messages",
+		},
+	)
+	metricWriteRelabelDroppedSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "write_relabel_dropped_series_total",
+			Help:      "Series dropped by write relabel configs, by the rule index",
+		},
+		[]string{"scope", "user", "rule"},
+	)
+	metricWritePackedMessageSeries = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "write_packed_message_series",
+			Help:      "Number of series in packed Kafka messages",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 7),
+		},
+	)
+	metricWriteKafkaPartitionMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "write_kafka_partition_messages_total",
+		},
+		[]string{"topic", "partition"},
+	)
+	metricWriteKafkaPartitionSkew = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "write_kafka_partition_skew_ratio",
+			Help:      "Recent messages of the busiest partition to the mean recent messages per partition of the topic",
+		},
+		[]string{"topic"},
+	)
+	metricCircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "circuit_breaker_state",
+			Help:      "State of the topic circuit breaker: 0 closed, 1 open, 2 half-open",
+		},
+		[]string{"topic"},
+	)
+	metricCircuitBreakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "circuit_breaker_transitions_total",
+		},
+		[]string{"topic", "from", "to"},
+	)
+	metricCircuitBreakerRejectedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "circuit_breaker_rejected_requests_total",
+		},
+		[]string{"topic"},
+	)
+	metricRouteMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "route_messages_total",
+			Help:      "Series messages produced by the routing rule",
+		},
+		[]string{"route"},
+	)
+	metricGraphiteReceivedLines = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "graphite_received_lines_total",
+		},
+		[]string{"listener"},
+	)
+	metricGraphiteInvalidLines = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "graphite_invalid_lines_total",
+		},
+		[]string{"listener"},
+	)
+	metricGraphitePublishedSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "graphite_published_series_total",
+		},
+		[]string{"listener"},
+	)
+	metricGraphiteDroppedSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "graphite_dropped_series_total",
+			Help:      "Series dropped by mappings or failed to publish",
+		},
+		[]string{"listener", "reason"},
+	)
+	metricStatsDReceivedLines = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "statsd_received_lines_total",
+		},
+		[]string{"listener"},
+	)
+	metricStatsDInvalidLines = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "statsd_invalid_lines_total",
+		},
+		[]string{"listener"},
+	)
+	metricStatsDSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "statsd_series",
+			Help:      "Series aggregated by the listener",
+		},
+		[]string{"listener"},
+	)
+	metricStatsDPublishedSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "statsd_published_series_total",
+		},
+		[]string{"listener"},
+	)
+	metricStatsDDroppedSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "statsd_dropped_series_total",
+			Help:      "Lines dropped by mappings, of unsupported or conflicting types, and series failed to publish",
+		},
+		[]string{"listener", "reason"},
+	)
+	metricSpoolSizeBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "spool_size_bytes",
+		},
+	)
+	metricSpoolSegments = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "spool_segments",
+		},
+	)
+	metricSpoolOldestSegmentTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "spool_oldest_segment_timestamp_seconds",
+			Help:      "Creation time of the oldest spool segment, zero when the spool is empty",
+		},
+	)
+	metricSpoolWrittenMessages = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "spool_written_messages_total",
+		},
+	)
+	metricSpoolWriteErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "spool_write_errors_total",
+		},
+	)
+	metricSpoolReplayedMessages = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "spool_replayed_messages_total",
+		},
+	)
+	metricSpoolExpiredBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "spool_expired_bytes_total",
+			Help:      "Size of spool segments dropped after max_age without being replayed",
+		},
+	)
+	metricSpoolCorruptedRecords = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "spool_corrupted_records_total",
+			Help:      "Corrupted or truncated records skipped while reading spool segments",
+		},
+	)
 )
 
 func init() {
@@ -62,5 +288,33 @@ func init() {
 	prometheus.MustRegister(metricWriteBatchesReceivedUncompressedBytes)
 	prometheus.MustRegister(metricsWriteBatchesRequestsDuration)
 	prometheus.MustRegister(metricWriteBatchesRequestsEncoding)
+	prometheus.MustRegister(metricWriteBatchesRequestsProtoMsg)
+	prometheus.MustRegister(metricWriteMetadata)
 	prometheus.MustRegister(metricWriteKafkaMessages)
+	prometheus.MustRegister(metricWriteKafkaAckFailures)
+	prometheus.MustRegister(metricWriteRelabelDroppedSeries)
+	prometheus.MustRegister(metricWritePackedMessageSeries)
+	prometheus.MustRegister(metricWriteKafkaPartitionMessages)
+	prometheus.MustRegister(metricWriteKafkaPartitionSkew)
+	prometheus.MustRegister(metricCircuitBreakerState)
+	prometheus.MustRegister(metricCircuitBreakerTransitions)
+	prometheus.MustRegister(metricCircuitBreakerRejectedRequests)
+	prometheus.MustRegister(metricRouteMessages)
+	prometheus.MustRegister(metricGraphiteReceivedLines)
+	prometheus.MustRegister(metricGraphiteInvalidLines)
+	prometheus.MustRegister(metricGraphitePublishedSeries)
+	prometheus.MustRegister(metricGraphiteDroppedSeries)
+	prometheus.MustRegister(metricStatsDReceivedLines)
+	prometheus.MustRegister(metricStatsDInvalidLines)
+	prometheus.MustRegister(metricStatsDSeries)
+	prometheus.MustRegister(metricStatsDPublishedSeries)
+	prometheus.MustRegister(metricStatsDDroppedSeries)
+	prometheus.MustRegister(metricSpoolSizeBytes)
+	prometheus.MustRegister(metricSpoolSegments)
+	prometheus.MustRegister(metricSpoolOldestSegmentTimestamp)
+	prometheus.MustRegister(metricSpoolWrittenMessages)
+	prometheus.MustRegister(metricSpoolWriteErrors)
+	prometheus.MustRegister(metricSpoolReplayedMessages)
+	prometheus.MustRegister(metricSpoolExpiredBytes)
+	prometheus.MustRegister(metricSpoolCorruptedRecords)
 }
@@ -0,0 +1,44 @@
+package gateway
+
+import "strings"
+
+// sanitizeMetricName replaces characters not allowed in Prometheus metric names with underscores.
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizeLabelName replaces characters not allowed in Prometheus label names with underscores.
+func sanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+
+	var builder strings.Builder
+	builder.Grow(len(name) + 1)
+
+	for idx, char := range name {
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char == '_':
+			builder.WriteRune(char)
+
+		case char == ':' && allowColon:
+			builder.WriteRune(char)
+
+		case char >= '0' && char <= '9':
+			// names must not start with a digit
+			if idx == 0 {
+				builder.WriteByte('_')
+			}
+			builder.WriteRune(char)
+
+		default:
+			builder.WriteByte('_')
+		}
+	}
+
+	return builder.String()
+}
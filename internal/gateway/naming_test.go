@@ -0,0 +1,30 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		metric string
+		label  string
+	}{
+		{name: "valid", input: "http_requests_total", metric: "http_requests_total", label: "http_requests_total"},
+		{name: "colon", input: "job:up:sum", metric: "job:up:sum", label: "job_up_sum"},
+		{name: "dots and dashes", input: "cpu.usage-idle", metric: "cpu_usage_idle", label: "cpu_usage_idle"},
+		{name: "leading digit", input: "1m_load", metric: "_1m_load", label: "_1m_load"},
+		{name: "unicode", input: "température", metric: "temp_rature", label: "temp_rature"},
+		{name: "empty", input: "", metric: "_", label: "_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.metric, sanitizeMetricName(tt.input))
+			assert.Equal(t, tt.label, sanitizeLabelName(tt.input))
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"encoding/binary"
+
+	"github.com/IBM/sarama"
+)
+
+// writeRequestTimeseriesTag is the protobuf tag of the length-delimited prompb.WriteRequest.Timeseries field.
+const writeRequestTimeseriesTag = 1<<3 | 2
+
+type packKey struct {
+	target routeTarget
+	key    string
+}
+
+type pack struct {
+	buf    []byte
+	series int
+}
+
+// messagePacker groups marshaled series sharing the topic and the partition key into prompb.WriteRequest
+// messages bounded by bytes and series count. Series keep their order within the partition key.
+type messagePacker struct {
+	config  PackingConfig
+	headers []sarama.RecordHeader
+
+	messages []*sarama.ProducerMessage
+	packs    map[packKey]*pack
+	order    []packKey
+}
+
+func newMessagePacker(config PackingConfig, headers []sarama.RecordHeader) *messagePacker {
+	return &messagePacker{
+		config:  config,
+		headers: headers,
+		packs:   make(map[packKey]*pack),
+	}
+}
+
+// add appends the marshaled prompb.TimeSeries to the pack of its target and partition key.
+func (p *messagePacker) add(target routeTarget, key string, series []byte) {
+	id := packKey{target: target, key: key}
+
+	// the write request is encoded by concatenating the repeated field, so series are not marshaled twice
+	var scratch [binary.MaxVarintLen64]byte
+	size := 1 + binary.PutUvarint(scratch[:], uint64(len(series))) + len(series)
+
+	current := p.packs[id]
+	if current != nil && (current.series >= p.config.MaxSeries || len(current.buf)+size > p.config.MaxBytes) {
+		p.flush(id)
+		current = nil
+	}
+
+	if current == nil {
+		current = &pack{}
+		p.packs[id] = current
+		p.order = append(p.order, id)
+	}
+
+	current.buf = append(current.buf, writeRequestTimeseriesTag)
+	current.buf = binary.AppendUvarint(current.buf, uint64(len(series)))
+	current.buf = append(current.buf, series...)
+	current.series++
+}
+
+func (p *messagePacker) flush(id packKey) {
+	current := p.packs[id]
+	delete(p.packs, id)
+
+	p.messages = append(p.messages, &sarama.ProducerMessage{
+		Topic:   id.target.topic,
+		Key:     id.target.partitionKey.keyEncoder(id.key),
+		Value:   sarama.ByteEncoder(current.buf),
+		Headers: p.headers,
+	})
+
+	metricWritePackedMessageSeries.Observe(float64(current.series))
+}
+
+// finish returns messages of all packs.
+func (p *messagePacker) finish() []*sarama.ProducerMessage {
+	for _, id := range p.order {
+		// the pack could be already flushed by its limits and started again later
+		if _, ok := p.packs[id]; ok {
+			p.flush(id)
+		}
+	}
+
+	return p.messages
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
+)
+
+func TestBuildMessagesPacking(t *testing.T) {
+	config := &Config{Kafka: KafkaConfig{
+		Topic:   "metrics",
+		Packing: PackingConfig{Enabled: true, MaxSeries: 2},
+	}}
+	config.Kafka.Packing.setDefaults()
+
+	g := &Gateway{config: config}
+
+	series := func(name string, value float64) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: name}},
+			Samples: []prompb.Sample{{Value: value, Timestamp: 1000}},
+		}
+	}
+
+	messages, _, err := g.buildMessages(&User{}, envelope.Envelope{}, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			series("up", 1),
+			series("down", 1),
+			series("up", 2),
+			series("up", 3),
+		},
+		Metadata: []prompb.MetricMetadata{{MetricFamilyName: "up"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, messages, 4)
+
+	decode := func(idx int) (string, []float64) {
+		assert.Equal(t, envelope.KindWriteRequest, parseTestEnvelope(t, messages[idx]).Kind)
+
+		value, err := messages[idx].Value.Encode()
+		require.NoError(t, err)
+
+		var req prompb.WriteRequest
+		require.NoError(t, proto.Unmarshal(value, &req))
+
+		var values []float64
+		for _, ts := range req.Timeseries {
+			values = append(values, ts.Samples[0].Value)
+		}
+
+		key, err := messages[idx].Key.Encode()
+		require.NoError(t, err)
+
+		return string(key), values
+	}
+
+	key, values := decode(0)
+	assert.Equal(t, "up", key)
+	assert.Equal(t, []float64{1, 2}, values, "full pack is flushed first")
+
+	key, values = decode(1)
+	assert.Equal(t, "up", key)
+	assert.Equal(t, []float64{3}, values)
+
+	key, values = decode(2)
+	assert.Equal(t, "down", key)
+	assert.Equal(t, []float64{1}, values)
+
+	assert.Equal(t, envelope.KindMetadata, parseTestEnvelope(t, messages[3]).Kind)
+}
+
+func TestMessagePackerMaxBytes(t *testing.T) {
+	packer := newMessagePacker(PackingConfig{MaxBytes: 100, MaxSeries: 1000}, nil)
+
+	target := routeTarget{topic: "metrics", partitionKey: &PartitionKeyConfig{}}
+
+	for range 10 {
+		packer.add(target, "up", make([]byte, 30))
+	}
+
+	messages := packer.finish()
+	require.Len(t, messages, 4)
+
+	for _, message := range messages {
+		assert.LessOrEqual(t, message.Value.Length(), 100)
+	}
+}
@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	partitionKeyMetricName       = "metric_name"
+	partitionKeyLabelsHash       = "labels_hash"
+	partitionKeyMetricNameLabels = "metric_name_labels"
+	partitionKeyTenant           = "tenant"
+	partitionKeyRandom           = "random"
+	partitionKeyRoundRobin       = "round_robin"
+
+	// partitionSkewWindow is the period messages per partition are halved after, so the skew follows
+	// the recent distribution of messages.
+	partitionSkewWindow = time.Minute
+)
+
+// PartitionKeyConfig selects how the Kafka key of the series is built, which defines its partition.
+type PartitionKeyConfig struct {
+	Strategy string `yaml:"strategy"`
+	// Labels are added to the metric name by the metric_name_labels strategy.
+	Labels []string `yaml:"labels"`
+}
+
+func (c *PartitionKeyConfig) validate() error {
+	switch c.Strategy {
+	case "", partitionKeyMetricName, partitionKeyLabelsHash, partitionKeyTenant, partitionKeyRandom, partitionKeyRoundRobin:
+		if len(c.Labels) > 0 {
+			return fmt.Errorf("labels are supported only by the %s partition key strategy", partitionKeyMetricNameLabels)
+		}
+
+	case partitionKeyMetricNameLabels:
+		if len(c.Labels) == 0 {
+			return fmt.Errorf("labels are required by the %s partition key strategy", partitionKeyMetricNameLabels)
+		}
+
+	default:
+		return fmt.Errorf("unknown partition key strategy: %s", c.Strategy)
+	}
+
+	return nil
+}
+
+// partitionKey returns the Kafka key of the series. Random and round-robin strategies return
+// an empty key, the message is unkeyed then.
+func (c *PartitionKeyConfig) partitionKey(series []prompb.Label, tenant string) string {
+	switch c.Strategy {
+	case partitionKeyLabelsHash:
+		return hashLabels(series)
+
+	case partitionKeyMetricNameLabels:
+		key := getKafkaKey(series)
+		for _, name := range c.Labels {
+			key += ";" + getLabelValue(series, name)
+		}
+
+		return key
+
+	case partitionKeyTenant:
+		if tenant == "" {
+			// requests without a tenant would all be produced to a single partition
+			return getKafkaKey(series)
+		}
+
+		return tenant
+
+	case partitionKeyRandom, partitionKeyRoundRobin:
+		return ""
+
+	default:
+		return getKafkaKey(series)
+	}
+}
+
+// keyEncoder returns the Kafka key encoder of the message, which also tells the partitioner how to
+// choose the partition of unkeyed messages.
+func (c *PartitionKeyConfig) keyEncoder(key string) sarama.Encoder {
+	switch c.Strategy {
+	case partitionKeyRandom:
+		return nil
+
+	case partitionKeyRoundRobin:
+		return roundRobinKey{}
+
+	default:
+		return sarama.StringEncoder(key)
+	}
+}
+
+// hashLabels returns the hash of the label set independent of the order of labels.
+func hashLabels(series []prompb.Label) string {
+	if !slices.IsSortedFunc(series, compareLabels) {
+		series = slices.SortedFunc(slices.Values(series), compareLabels)
+	}
+
+	hash := fnv.New64a()
+	for _, label := range series {
+		hash.Write([]byte(label.Name))
+		hash.Write([]byte{0xff})
+		hash.Write([]byte(label.Value))
+		hash.Write([]byte{0xff})
+	}
+
+	return fmt.Sprintf("h-%d", hash.Sum64())
+}
+
+func compareLabels(a, b prompb.Label) int {
+	return strings.Compare(a.Name, b.Name)
+}
+
+// roundRobinKey is the key of unkeyed messages distributed between partitions in turn.
+type roundRobinKey struct{}
+
+func (roundRobinKey) Encode() ([]byte, error) { return nil, nil }
+func (roundRobinKey) Length() int             { return 0 }
+
+// keyPartitioner hashes keyed messages, the same way the default partitioner does, and distributes
+// unkeyed ones randomly or in turn. It also accounts messages per partition to expose the partition skew.
+type keyPartitioner struct {
+	topic  string
+	hash   sarama.Partitioner
+	random sarama.Partitioner
+
+	mu       sync.Mutex
+	next     int32
+	skew     prometheus.Gauge
+	counters []prometheus.Counter
+	counts   []uint64
+	total    uint64
+	max      uint64
+	// decayed is the time counts were halved last time
+	decayed time.Time
+}
+
+func newKeyPartitioner(topic string) sarama.Partitioner {
+	return &keyPartitioner{
+		topic:  topic,
+		hash:   sarama.NewHashPartitioner(topic),
+		random: sarama.NewRandomPartitioner(topic),
+		skew:   metricWriteKafkaPartitionSkew.WithLabelValues(topic),
+	}
+}
+
+func (p *keyPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	var partition int32
+	var err error
+
+	switch message.Key.(type) {
+	case roundRobinKey:
+		p.mu.Lock()
+		partition = p.next % numPartitions
+		p.next = (partition + 1) % numPartitions
+		p.mu.Unlock()
+
+	case nil:
+		partition, err = p.random.Partition(message, numPartitions)
+
+	default:
+		partition, err = p.hash.Partition(message, numPartitions)
+	}
+
+	if err != nil {
+		return -1, err
+	}
+
+	p.observe(partition, numPartitions)
+
+	return partition, nil
+}
+
+// RequiresConsistency is always true, so the partition is chosen among all partitions of the topic and
+// the choice is the partition ID the skew is accounted by.
+func (p *keyPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+func (p *keyPartitioner) observe(partition, numPartitions int32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.counts) != int(numPartitions) {
+		// partitions were added to the topic, start accounting over
+		p.counts = make([]uint64, numPartitions)
+		p.counters = make([]prometheus.Counter, numPartitions)
+		p.total = 0
+		p.max = 0
+		p.decayed = time.Now()
+
+		for idx := range p.counters {
+			p.counters[idx] = metricWriteKafkaPartitionMessages.WithLabelValues(p.topic, strconv.Itoa(idx))
+		}
+	}
+
+	if time.Since(p.decayed) >= partitionSkewWindow {
+		p.decay()
+	}
+
+	p.counters[partition].Inc()
+
+	p.counts[partition]++
+	p.total++
+	p.max = max(p.max, p.counts[partition])
+
+	// the ratio of the busiest partition to the mean, 1 is the even distribution
+	p.skew.Set(float64(p.max) * float64(numPartitions) / float64(p.total))
+}
+
+// decay halves messages of every partition, so older messages weigh less in the skew.
+func (p *keyPartitioner) decay() {
+	p.total = 0
+	p.max = 0
+	p.decayed = time.Now()
+
+	for idx := range p.counts {
+		p.counts[idx] /= 2
+		p.total += p.counts[idx]
+		p.max = max(p.max, p.counts[idx])
+	}
+}
@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
+)
+
+func TestPartitionKey(t *testing.T) {
+	series := []prompb.Label{
+		{Name: "__name__", Value: "http_requests_total"},
+		{Name: "instance", Value: "host:9100"},
+		{Name: "job", Value: "api"},
+	}
+
+	tests := []struct {
+		name   string
+		config PartitionKeyConfig
+		want   string
+	}{
+		{
+			name:   "metric name by default",
+			config: PartitionKeyConfig{},
+			want:   "http_requests_total",
+		},
+		{
+			name:   "metric name and labels",
+			config: PartitionKeyConfig{Strategy: partitionKeyMetricNameLabels, Labels: []string{"job", "instance"}},
+			want:   "http_requests_total;api;host:9100",
+		},
+		{
+			name:   "tenant",
+			config: PartitionKeyConfig{Strategy: partitionKeyTenant},
+			want:   "42",
+		},
+		{
+			name:   "round robin",
+			config: PartitionKeyConfig{Strategy: partitionKeyRoundRobin},
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.config.partitionKey(series, "42"))
+		})
+	}
+
+	t.Run("metric name without tenant", func(t *testing.T) {
+		config := PartitionKeyConfig{Strategy: partitionKeyTenant}
+
+		assert.Equal(t, "http_requests_total", config.partitionKey(series, ""))
+	})
+
+	t.Run("labels hash does not depend on the order of labels", func(t *testing.T) {
+		config := PartitionKeyConfig{Strategy: partitionKeyLabelsHash}
+
+		reversed := []prompb.Label{series[2], series[1], series[0]}
+
+		assert.Equal(t, config.partitionKey(series, ""), config.partitionKey(reversed, ""))
+		assert.NotEqual(t, config.partitionKey(series, ""), config.partitionKey(series[:2], ""))
+	})
+}
+
+func TestPartitionKeyValidation(t *testing.T) {
+	assert.NoError(t, (&PartitionKeyConfig{Strategy: partitionKeyRandom}).validate())
+	assert.Error(t, (&PartitionKeyConfig{Strategy: "unknown"}).validate())
+	assert.Error(t, (&PartitionKeyConfig{Strategy: partitionKeyMetricNameLabels}).validate())
+	assert.Error(t, (&PartitionKeyConfig{Strategy: partitionKeyTenant, Labels: []string{"job"}}).validate())
+}
+
+func TestKeyPartitioner(t *testing.T) {
+	t.Run("round robin", func(t *testing.T) {
+		partitioner := newKeyPartitioner("test-round-robin")
+
+		var partitions []int32
+		for range 5 {
+			partition, err := partitioner.Partition(&sarama.ProducerMessage{Key: roundRobinKey{}}, 3)
+			require.NoError(t, err)
+
+			partitions = append(partitions, partition)
+		}
+
+		assert.Equal(t, []int32{0, 1, 2, 0, 1}, partitions)
+		assert.InDelta(t, 1.2, testutil.ToFloat64(metricWriteKafkaPartitionSkew.WithLabelValues("test-round-robin")), 0.001)
+	})
+
+	t.Run("keyed messages are hashed", func(t *testing.T) {
+		partitioner := newKeyPartitioner("test-hash")
+		hash := sarama.NewHashPartitioner("test-hash")
+
+		for _, key := range []string{"up", "down", "http_requests_total"} {
+			message := &sarama.ProducerMessage{Key: sarama.StringEncoder(key)}
+
+			want, err := hash.Partition(message, 16)
+			require.NoError(t, err)
+
+			got, err := partitioner.Partition(message, 16)
+			require.NoError(t, err)
+
+			assert.Equal(t, want, got)
+		}
+	})
+
+	t.Run("single hot key is visible as skew", func(t *testing.T) {
+		partitioner := newKeyPartitioner("test-skew")
+
+		for range 10 {
+			_, err := partitioner.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder("up")}, 4)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, 4.0, testutil.ToFloat64(metricWriteKafkaPartitionSkew.WithLabelValues("test-skew")))
+	})
+
+	t.Run("skew follows recent messages", func(t *testing.T) {
+		partitioner := newKeyPartitioner("test-skew-window").(*keyPartitioner)
+
+		for range 10 {
+			_, err := partitioner.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder("up")}, 4)
+			require.NoError(t, err)
+		}
+
+		// messages are distributed evenly in the following windows
+		for range 3 {
+			partitioner.decayed = partitioner.decayed.Add(-partitionSkewWindow)
+
+			for range 8 {
+				_, err := partitioner.Partition(&sarama.ProducerMessage{Key: roundRobinKey{}}, 4)
+				require.NoError(t, err)
+			}
+		}
+
+		assert.Less(t, testutil.ToFloat64(metricWriteKafkaPartitionSkew.WithLabelValues("test-skew-window")), 1.5)
+	})
+}
+
+func TestRoutePartitionKey(t *testing.T) {
+	config := loadTestConfig(t, `
+kafka:
+  topic: metrics
+  partition_key:
+    strategy: labels_hash
+routes:
+  - name: histograms
+    topic: metrics-histograms
+    metric_name: .+_bucket
+    partition_key:
+      strategy: round_robin
+`)
+
+	g := &Gateway{config: config}
+
+	messages, _, err := g.buildMessages(&User{}, envelope.Envelope{}, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: "__name__", Value: "rpc_duration_seconds_bucket"}}},
+			{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+
+	assert.Equal(t, roundRobinKey{}, messages[0].Key)
+	assert.Equal(t, sarama.StringEncoder(hashLabels([]prompb.Label{{Name: "__name__", Value: "up"}})), messages[1].Key)
+}
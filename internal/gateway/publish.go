@@ -0,0 +1,207 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
+)
+
+// buildMessages converts the write request of the user to Kafka messages. Series are produced to the topics
+// of the matching routes, or to the topic of the user when no route matches. The envelope of the request
+// is attached to every message as Kafka record headers. With packing enabled, series sharing the topic
+// and the partition key are produced as a single message. Indexes of the series dropped by relabeling are returned.
+func (g *Gateway) buildMessages(user *User, meta envelope.Envelope, req *prompb.WriteRequest) ([]*sarama.ProducerMessage, []int, error) {
+	kafkaTopic := g.config.Kafka.Topic
+	if user.Topic != nil {
+		kafkaTopic = *user.Topic
+	}
+
+	meta.SchemaVersion = envelope.SchemaVersion
+
+	meta.Kind = envelope.KindTimeSeries
+	seriesHeaders := meta.Headers()
+
+	meta.Kind = envelope.KindMetadata
+	metadataHeaders := meta.Headers()
+
+	meta.Kind = envelope.KindWriteRequest
+	packer := newMessagePacker(g.config.Kafka.Packing, meta.Headers())
+
+	messages := make([]*sarama.ProducerMessage, 0, len(req.Timeseries)+len(req.Metadata))
+
+	var dropped []int
+
+	for idx, ts := range req.GetTimeseries() {
+		seriesLabels, keep := g.relabelSeries(user, ts.Labels)
+		if !keep {
+			dropped = append(dropped, idx)
+			continue
+		}
+
+		// reconstruct the original TimeSeries
+		messgaeWriteRequest := &prompb.TimeSeries{
+			Labels:     seriesLabels,
+			Exemplars:  ts.Exemplars,
+			Samples:    ts.Samples,
+			Histograms: ts.Histograms,
+		}
+
+		messageBytes, err := proto.Marshal(messgaeWriteRequest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error marshaling protobuf: %w", err)
+		}
+
+		for _, target := range g.routeTargets(seriesLabels, kafkaTopic) {
+			key := target.partitionKey.partitionKey(seriesLabels, meta.Tenant)
+
+			if g.config.Kafka.Packing.Enabled {
+				packer.add(target, key, messageBytes)
+				continue
+			}
+
+			messages = append(messages, &sarama.ProducerMessage{
+				Topic:   target.topic,
+				Key:     target.partitionKey.keyEncoder(key),
+				Value:   sarama.ByteEncoder(messageBytes),
+				Headers: seriesHeaders,
+			})
+		}
+	}
+
+	messages = append(messages, packer.finish()...)
+
+	metadataTopic := kafkaTopic
+	if g.config.Kafka.MetadataTopic != "" {
+		metadataTopic = g.config.Kafka.MetadataTopic
+	}
+
+	for _, metadata := range req.GetMetadata() {
+		messageBytes, err := proto.Marshal(&metadata)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error marshaling protobuf: %w", err)
+		}
+
+		messages = append(messages, &sarama.ProducerMessage{
+			Topic:   metadataTopic,
+			Key:     sarama.StringEncoder(metadata.MetricFamilyName),
+			Value:   sarama.ByteEncoder(messageBytes),
+			Headers: metadataHeaders,
+		})
+
+		metricWriteMetadata.Inc()
+	}
+
+	return messages, dropped, nil
+}
+
+// publishSeries produces series received by a listener of the protocol, outside of HTTP write requests.
+func (g *Gateway) publishSeries(user *User, protocol string, receivedAt time.Time, series []prompb.TimeSeries) error {
+	messages, _, err := g.buildMessages(user, envelope.Envelope{
+		Tenant:     user.Tenant,
+		User:       user.Login,
+		Protocol:   protocol,
+		ReceivedAt: receivedAt,
+	}, &prompb.WriteRequest{Timeseries: series})
+	if err != nil {
+		return err
+	}
+
+	return g.publish(messages)
+}
+
+// publish produces messages to Kafka. While the circuit breaker of any of their topics is open, or for
+// messages Kafka failed to accept, the messages are written to the spool when it is enabled.
+func (g *Gateway) publish(messages []*sarama.ProducerMessage) error {
+	if openErr := g.breakers.check(messages); openErr != nil {
+		if g.spool == nil {
+			metricCircuitBreakerRejectedRequests.WithLabelValues(openErr.topic).Inc()
+
+			return openErr
+		}
+
+		return g.spool.write(messages)
+	}
+
+	var ack *produceAck
+	if g.config.Kafka.SyncAck {
+		ack = &produceAck{}
+	}
+
+	for idx, message := range messages {
+		if err := g.produce(message, ack); err != nil {
+			if g.spool == nil {
+				return err
+			}
+
+			if err := g.spool.write(messages[idx:]); err != nil {
+				return err
+			}
+
+			break
+		}
+	}
+
+	if ack == nil {
+		return nil
+	}
+
+	if err := ack.wait(g.getKafkaWriteTimeout()); err != nil {
+		// messages still in flight after the timeout may fail later, when the ack is no longer waited for,
+		// so every message which is not confirmed is spooled, at the cost of possible duplicates. Once they
+		// are spooled, the request succeeds, so the client does not send them again.
+		undelivered := ack.undeliveredMessages()
+
+		if g.spool != nil && len(undelivered) > 0 {
+			if spoolErr := g.spool.write(undelivered); spoolErr != nil {
+				metricWriteKafkaAckFailures.Inc()
+
+				return spoolErr
+			}
+
+			return nil
+		}
+
+		metricWriteKafkaAckFailures.Inc()
+
+		return err
+	}
+
+	return nil
+}
+
+// produce sends the message to Kafka. When ack is set, the delivery report of the message is reported to it.
+func (g *Gateway) produce(message *sarama.ProducerMessage, ack *produceAck) error {
+	metricWriteKafkaMessages.WithLabelValues(message.Topic).Inc()
+
+	if ack != nil {
+		message.Metadata = ack
+		ack.add(message)
+	}
+
+	select {
+	case g.kafkaProducer.Input() <- message:
+		return nil
+
+	case <-time.After(g.getKafkaWriteTimeout()):
+		if ack != nil {
+			ack.discard(message)
+		}
+
+		g.breakers.record(message.Topic, false)
+
+		return errors.New("timeout writing to kafka")
+	}
+}
+
+func (g *Gateway) getKafkaWriteTimeout() time.Duration {
+	config := g.kafkaClient.Config()
+
+	// the message is not retried with retry_max of zero, but it still waits for a single attempt
+	return config.Producer.Timeout * time.Duration(max(config.Producer.Retry.Max, 1))
+}
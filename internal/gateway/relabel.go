@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"strconv"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// relabelSeries applies write relabel configs of the user and then the global ones, so the global rules
+// act as the gateway policy, and finally sets external labels of the user, so they cannot be overwritten
+// by the client or relabeling. It returns false when the series is dropped.
+func (g *Gateway) relabelSeries(user *User, series []prompb.Label) ([]prompb.Label, bool) {
+	if len(user.WriteRelabelConfigs) == 0 && len(g.config.WriteRelabelConfigs) == 0 && len(user.ExternalLabels) == 0 {
+		return series, true
+	}
+
+	builder := labels.NewBuilder(labels.EmptyLabels())
+	for _, label := range series {
+		builder.Set(label.Name, label.Value)
+	}
+
+	if !processRelabelConfigs(builder, user.WriteRelabelConfigs, "user", user.Login) {
+		return nil, false
+	}
+
+	if !processRelabelConfigs(builder, g.config.WriteRelabelConfigs, "global", "") {
+		return nil, false
+	}
+
+	for name, value := range user.ExternalLabels {
+		builder.Set(name, value)
+	}
+
+	result := builder.Labels()
+	if result.IsEmpty() {
+		return nil, false
+	}
+
+	return prompb.FromLabels(result, nil), true
+}
+
+// processRelabelConfigs applies the rules one by one to count which of them dropped the series.
+func processRelabelConfigs(builder *labels.Builder, cfgs []*relabel.Config, scope, login string) bool {
+	for idx, cfg := range cfgs {
+		if !relabel.ProcessBuilder(builder, cfg) {
+			metricWriteRelabelDroppedSeries.WithLabelValues(scope, login, strconv.Itoa(idx)).Inc()
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadTestConfig(t *testing.T, data string) *Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	config, err := loadConfig(path)
+	require.NoError(t, err)
+
+	return config
+}
+
+func TestRelabelSeries(t *testing.T) {
+	config := loadTestConfig(t, `
+kafka:
+  topic: metrics
+write_relabel_configs:
+  - source_labels: [__name__]
+    regex: go_.*
+    action: drop
+  - regex: instance
+    action: labeldrop
+users:
+  - login: user1
+    password: pass1
+    write_relabel_configs:
+      - target_label: team
+        replacement: backend
+`)
+
+	g := &Gateway{config: config}
+	user := &config.Users[0]
+
+	tests := []struct {
+		name   string
+		user   *User
+		labels []prompb.Label
+		want   []prompb.Label
+		keep   bool
+	}{
+		{
+			name: "labels are rewritten",
+			user: user,
+			labels: []prompb.Label{
+				{Name: "__name__", Value: "up"},
+				{Name: "instance", Value: "host:9100"},
+			},
+			want: []prompb.Label{
+				{Name: "__name__", Value: "up"},
+				{Name: "team", Value: "backend"},
+			},
+			keep: true,
+		},
+		{
+			name:   "series is dropped by the global rule",
+			user:   user,
+			labels: []prompb.Label{{Name: "__name__", Value: "go_goroutines"}},
+			keep:   false,
+		},
+		{
+			name:   "user rules are not applied to other users",
+			user:   &User{},
+			labels: []prompb.Label{{Name: "__name__", Value: "up"}},
+			want:   []prompb.Label{{Name: "__name__", Value: "up"}},
+			keep:   true,
+		},
+		{
+			name:   "series without labels is dropped",
+			user:   &User{},
+			labels: []prompb.Label{{Name: "instance", Value: "host:9100"}},
+			keep:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, keep := g.relabelSeries(tt.user, tt.labels)
+
+			assert.Equal(t, tt.keep, keep)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRelabelConfigValidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+write_relabel_configs:
+  - action: replace
+    replacement: value
+`), 0o600))
+
+	_, err := loadConfig(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Route sends the matching series to its topic. A series matches when its metric name matches
+// the anchored regex and all label matchers match. Routes are evaluated in order and the first
+// matching route wins, unless it has Continue set, then the following routes are evaluated too
+// and the series is produced to each matching topic. Routes take precedence over the topic of the user,
+// which receives only the series matching no route.
+type Route struct {
+	Name       string   `yaml:"name"`
+	Topic      string   `yaml:"topic"`
+	MetricName string   `yaml:"metric_name"`
+	Matchers   []string `yaml:"matchers"`
+	Continue   bool     `yaml:"continue"`
+
+	// PartitionKey overrides the partition key strategy for the series of the route.
+	PartitionKey *PartitionKeyConfig `yaml:"partition_key"`
+
+	metricName *regexp.Regexp
+	matchers   []*labels.Matcher
+}
+
+func (r *Route) compile() error {
+	if r.Name == "" {
+		return errors.New("route name is required")
+	}
+
+	if r.Topic == "" {
+		return errors.New("route topic is required")
+	}
+
+	if r.MetricName == "" && len(r.Matchers) == 0 {
+		return errors.New("route requires metric_name or matchers")
+	}
+
+	if r.PartitionKey != nil {
+		if err := r.PartitionKey.validate(); err != nil {
+			return err
+		}
+	}
+
+	if r.MetricName != "" {
+		regex, err := regexp.Compile("^(?s:" + r.MetricName + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid metric_name regex: %w", err)
+		}
+
+		r.metricName = regex
+	}
+
+	if len(r.Matchers) > 0 {
+		matchers, err := parser.ParseMetricSelector("{" + strings.Join(r.Matchers, ",") + "}")
+		if err != nil {
+			return fmt.Errorf("invalid matchers: %w", err)
+		}
+
+		r.matchers = matchers
+	}
+
+	return nil
+}
+
+func (r *Route) matches(series []prompb.Label) bool {
+	if r.metricName != nil && !r.metricName.MatchString(getMetricName(series)) {
+		return false
+	}
+
+	for _, matcher := range r.matchers {
+		if !matcher.Matches(getLabelValue(series, matcher.Name)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// routeTarget is the topic the series is produced to with its partition key strategy.
+type routeTarget struct {
+	topic        string
+	partitionKey *PartitionKeyConfig
+}
+
+// routeTargets returns targets of the routes matching the series, or the default topic when no route matches.
+func (g *Gateway) routeTargets(series []prompb.Label, defaultTopic string) []routeTarget {
+	var targets []routeTarget
+
+	for _, route := range g.config.Routes {
+		if !route.matches(series) {
+			continue
+		}
+
+		metricRouteMessages.WithLabelValues(route.Name).Inc()
+
+		// several routes may share the topic, the series is produced to it only once
+		if !slices.ContainsFunc(targets, func(target routeTarget) bool { return target.topic == route.Topic }) {
+			partitionKey := route.PartitionKey
+			if partitionKey == nil {
+				partitionKey = &g.config.Kafka.PartitionKey
+			}
+
+			targets = append(targets, routeTarget{topic: route.Topic, partitionKey: partitionKey})
+		}
+
+		if !route.Continue {
+			break
+		}
+	}
+
+	if len(targets) == 0 {
+		targets = append(targets, routeTarget{topic: defaultTopic, partitionKey: &g.config.Kafka.PartitionKey})
+	}
+
+	return targets
+}
+
+func getLabelValue(series []prompb.Label, name string) string {
+	for _, label := range series {
+		if label.Name == name {
+			return label.Value
+		}
+	}
+
+	return ""
+}
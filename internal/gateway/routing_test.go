@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
+)
+
+func TestRouteTopics(t *testing.T) {
+	config := loadTestConfig(t, `
+kafka:
+  topic: metrics
+routes:
+  - name: histograms
+    topic: metrics-histograms
+    metric_name: .+_bucket
+  - name: recording-rules
+    topic: metrics-recording
+    metric_name: .+:.+
+    continue: true
+  - name: api-recording-rules
+    topic: metrics-api
+    metric_name: .+:.+
+    matchers: ['job=~"api.*"', 'env!="dev"']
+`)
+
+	g := &Gateway{config: config}
+
+	tests := []struct {
+		name   string
+		labels []prompb.Label
+		want   []string
+	}{
+		{
+			name:   "first matching route wins",
+			labels: []prompb.Label{{Name: "__name__", Value: "http_duration_seconds_bucket"}},
+			want:   []string{"metrics-histograms"},
+		},
+		{
+			name:   "metric name regex is anchored",
+			labels: []prompb.Label{{Name: "__name__", Value: "http_duration_seconds_bucket_total"}},
+			want:   []string{"metrics"},
+		},
+		{
+			name: "continue fans out to the following routes",
+			labels: []prompb.Label{
+				{Name: "__name__", Value: "job:requests:rate5m"},
+				{Name: "job", Value: "api-server"},
+			},
+			want: []string{"metrics-recording", "metrics-api"},
+		},
+		{
+			name: "all matchers must match",
+			labels: []prompb.Label{
+				{Name: "__name__", Value: "job:requests:rate5m"},
+				{Name: "env", Value: "dev"},
+				{Name: "job", Value: "api-server"},
+			},
+			want: []string{"metrics-recording"},
+		},
+		{
+			name:   "no route matches",
+			labels: []prompb.Label{{Name: "__name__", Value: "up"}},
+			want:   []string{"metrics"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var topics []string
+			for _, target := range g.routeTargets(tt.labels, "metrics") {
+				topics = append(topics, target.topic)
+			}
+
+			assert.Equal(t, tt.want, topics)
+		})
+	}
+}
+
+func TestBuildMessagesRouting(t *testing.T) {
+	config := loadTestConfig(t, `
+kafka:
+  topic: metrics
+routes:
+  - name: histograms
+    topic: metrics-histograms
+    metric_name: .+_bucket
+    continue: true
+  - name: all-histograms
+    topic: metrics-histograms
+    metric_name: .+_(bucket|sum|count)
+`)
+
+	g := &Gateway{config: config}
+	topic := "metrics-user"
+
+	messages, _, err := g.buildMessages(&User{Topic: &topic}, envelope.Envelope{}, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: "__name__", Value: "rpc_duration_seconds_bucket"}}},
+			{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+
+	// the matching route takes precedence over the topic of the user
+	assert.Equal(t, "metrics-histograms", messages[0].Topic)
+	assert.Equal(t, "metrics-user", messages[1].Topic)
+}
+
+func TestRouteValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes string
+	}{
+		{
+			name: "missing topic",
+			routes: `
+  - name: a
+    metric_name: up`,
+		},
+		{
+			name: "missing match",
+			routes: `
+  - name: a
+    topic: a`,
+		},
+		{
+			name: "invalid regex",
+			routes: `
+  - name: a
+    topic: a
+    metric_name: "("`,
+		},
+		{
+			name: "invalid matcher",
+			routes: `
+  - name: a
+    topic: a
+    matchers: ['job~"api"']`,
+		},
+		{
+			name: "duplicate name",
+			routes: `
+  - name: a
+    topic: a
+    metric_name: up
+  - name: a
+    topic: b
+    metric_name: down`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte("routes:"+tt.routes+"\n"), 0o600))
+
+			_, err := loadConfig(path)
+			assert.Error(t, err)
+		})
+	}
+}
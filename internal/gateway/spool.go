@@ -0,0 +1,474 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	spoolSegmentExt = ".seg"
+
+	// record header is the payload length and its crc32 checksum
+	spoolRecordHeaderSize = 8
+)
+
+var errSpoolFull = errors.New("spool is full")
+
+// spoolSegment is a closed segment file, named by its creation time in nanoseconds.
+type spoolSegment struct {
+	path    string
+	size    int64
+	created time.Time
+}
+
+// spool is an on-disk write-ahead queue of Kafka messages, used while Kafka is unavailable.
+// Messages are appended to the current segment, which is rotated by size, and replayed
+// segment by segment, oldest first.
+type spool struct {
+	config SpoolConfig
+
+	mu         sync.Mutex
+	segments   []spoolSegment
+	current    *os.File
+	currentSeg spoolSegment
+	totalSize  int64
+}
+
+func newSpool(config SpoolConfig) (*spool, error) {
+	if err := os.MkdirAll(config.Path, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	s := &spool{config: config}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolSegmentExt) {
+			continue
+		}
+
+		created, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), spoolSegmentExt), 10, 64)
+		if err != nil {
+			log.Printf("skipping unknown spool file: %s", entry.Name())
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat spool segment: %w", err)
+		}
+
+		s.segments = append(s.segments, spoolSegment{
+			path:    filepath.Join(config.Path, entry.Name()),
+			size:    info.Size(),
+			created: time.Unix(0, created),
+		})
+		s.totalSize += info.Size()
+	}
+
+	slices.SortFunc(s.segments, func(a, b spoolSegment) int {
+		return a.created.Compare(b.created)
+	})
+
+	s.updateMetrics()
+
+	return s, nil
+}
+
+// write appends messages to the current segment and syncs it to disk.
+func (s *spool) write(messages []*sarama.ProducerMessage) error {
+	var buf []byte
+
+	for _, message := range messages {
+		payload, err := encodeSpoolMessage(message)
+		if err != nil {
+			return err
+		}
+
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+		buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+		buf = append(buf, payload...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.totalSize+int64(len(buf)) > s.config.MaxSize {
+		metricSpoolWriteErrors.Inc()
+		return errSpoolFull
+	}
+
+	if s.current != nil && s.currentSeg.size+int64(len(buf)) > s.config.MaxSegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if s.current == nil {
+		now := time.Now()
+		path := filepath.Join(s.config.Path, fmt.Sprintf("%020d%s", now.UnixNano(), spoolSegmentExt))
+
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			metricSpoolWriteErrors.Inc()
+			return fmt.Errorf("failed to create spool segment: %w", err)
+		}
+
+		s.current = file
+		s.currentSeg = spoolSegment{path: path, created: now}
+	}
+
+	if _, err := s.current.Write(buf); err != nil {
+		metricSpoolWriteErrors.Inc()
+		return fmt.Errorf("failed to write spool segment: %w", err)
+	}
+
+	if err := s.current.Sync(); err != nil {
+		metricSpoolWriteErrors.Inc()
+		return fmt.Errorf("failed to sync spool segment: %w", err)
+	}
+
+	s.currentSeg.size += int64(len(buf))
+	s.totalSize += int64(len(buf))
+
+	metricSpoolWrittenMessages.Add(float64(len(messages)))
+	s.updateMetrics()
+
+	return nil
+}
+
+// rotate closes the current segment and makes it available for replay. Must be called with the lock held.
+func (s *spool) rotate() error {
+	if s.current == nil {
+		return nil
+	}
+
+	if err := s.current.Close(); err != nil {
+		return fmt.Errorf("failed to close spool segment: %w", err)
+	}
+
+	s.segments = append(s.segments, s.currentSeg)
+	s.current = nil
+	s.currentSeg = spoolSegment{}
+
+	return nil
+}
+
+// oldest returns the oldest segment to replay, rotating the current one when it is the only one left.
+func (s *spool) oldest() (spoolSegment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 {
+		if err := s.rotate(); err != nil {
+			log.Printf("failed to rotate spool segment: %v", err)
+		}
+	}
+
+	if len(s.segments) == 0 {
+		return spoolSegment{}, false
+	}
+
+	return s.segments[0], true
+}
+
+// remove deletes the replayed or expired segment.
+func (s *spool) remove(segment spoolSegment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove spool segment: %w", err)
+	}
+
+	s.segments = slices.DeleteFunc(s.segments, func(row spoolSegment) bool {
+		return row.path == segment.path
+	})
+	s.totalSize -= segment.size
+
+	s.updateMetrics()
+
+	return nil
+}
+
+// read returns all valid messages of the segment. Corrupted records and a torn record at the end
+// of the segment, left by a crash during write, are skipped.
+func (s *spool) read(segment spoolSegment) ([]*sarama.ProducerMessage, error) {
+	data, err := os.ReadFile(segment.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool segment: %w", err)
+	}
+
+	var messages []*sarama.ProducerMessage
+
+	for len(data) > 0 {
+		message, size, err := parseSpoolRecord(data)
+		if err != nil {
+			skip := nextSpoolRecord(data)
+			log.Printf("skipping %d bytes of corrupted record in spool segment %s: %v", skip, segment.path, err)
+
+			metricSpoolCorruptedRecords.Inc()
+			data = data[skip:]
+
+			continue
+		}
+
+		messages = append(messages, message)
+		data = data[size:]
+	}
+
+	return messages, nil
+}
+
+// parseSpoolRecord decodes the record at the start of data and returns its message and size.
+func parseSpoolRecord(data []byte) (*sarama.ProducerMessage, int, error) {
+	if len(data) < spoolRecordHeaderSize {
+		return nil, 0, errors.New("truncated record header")
+	}
+
+	size := binary.BigEndian.Uint32(data[0:4])
+	checksum := binary.BigEndian.Uint32(data[4:8])
+	payload := data[spoolRecordHeaderSize:]
+
+	if uint64(len(payload)) < uint64(size) {
+		return nil, 0, errors.New("truncated record")
+	}
+
+	if crc32.ChecksumIEEE(payload[:size]) != checksum {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+
+	message, err := decodeSpoolMessage(payload[:size])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return message, spoolRecordHeaderSize + int(size), nil
+}
+
+// nextSpoolRecord returns the offset of the first valid record after the corrupted one at the start of data,
+// or the length of data without one. The record following the length of the corrupted one is tried first,
+// as a corrupted payload usually keeps its length, then data is scanned for a valid record.
+func nextSpoolRecord(data []byte) int {
+	if len(data) >= spoolRecordHeaderSize {
+		next := uint64(spoolRecordHeaderSize) + uint64(binary.BigEndian.Uint32(data[0:4]))
+		if next < uint64(len(data)) {
+			if _, _, err := parseSpoolRecord(data[next:]); err == nil {
+				return int(next)
+			}
+		}
+	}
+
+	for offset := 1; offset < len(data); offset++ {
+		if _, _, err := parseSpoolRecord(data[offset:]); err == nil {
+			return offset
+		}
+	}
+
+	return len(data)
+}
+
+func (s *spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rotate()
+}
+
+// updateMetrics must be called with the lock held.
+func (s *spool) updateMetrics() {
+	segments := len(s.segments)
+	oldest := s.currentSeg.created
+
+	if segments > 0 {
+		oldest = s.segments[0].created
+	}
+
+	if s.current != nil {
+		segments++
+	}
+
+	metricSpoolSizeBytes.Set(float64(s.totalSize))
+	metricSpoolSegments.Set(float64(segments))
+
+	if segments > 0 {
+		metricSpoolOldestSegmentTimestamp.Set(float64(oldest.Unix()))
+	} else {
+		metricSpoolOldestSegmentTimestamp.Set(0)
+	}
+}
+
+// encodeSpoolMessage encodes topic, key, value and headers of the message as length-prefixed fields.
+func encodeSpoolMessage(message *sarama.ProducerMessage) ([]byte, error) {
+	var key, value []byte
+	var err error
+
+	if message.Key != nil {
+		if key, err = message.Key.Encode(); err != nil {
+			return nil, fmt.Errorf("failed to encode message key: %w", err)
+		}
+	}
+
+	if message.Value != nil {
+		if value, err = message.Value.Encode(); err != nil {
+			return nil, fmt.Errorf("failed to encode message value: %w", err)
+		}
+	}
+
+	buf := appendSpoolField(nil, []byte(message.Topic))
+	buf = appendSpoolField(buf, key)
+	buf = appendSpoolField(buf, value)
+	buf = binary.AppendUvarint(buf, uint64(len(message.Headers)))
+
+	for _, header := range message.Headers {
+		buf = appendSpoolField(buf, header.Key)
+		buf = appendSpoolField(buf, header.Value)
+	}
+
+	return buf, nil
+}
+
+func decodeSpoolMessage(data []byte) (*sarama.ProducerMessage, error) {
+	var topic, key, value []byte
+	var err error
+
+	if topic, data, err = readSpoolField(data); err != nil {
+		return nil, err
+	}
+
+	if key, data, err = readSpoolField(data); err != nil {
+		return nil, err
+	}
+
+	if value, data, err = readSpoolField(data); err != nil {
+		return nil, err
+	}
+
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, errors.New("malformed headers count")
+	}
+	data = data[n:]
+
+	message := &sarama.ProducerMessage{
+		Topic: string(topic),
+		Value: sarama.ByteEncoder(value),
+	}
+
+	if len(key) > 0 {
+		message.Key = sarama.ByteEncoder(key)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		var header sarama.RecordHeader
+
+		if header.Key, data, err = readSpoolField(data); err != nil {
+			return nil, err
+		}
+
+		if header.Value, data, err = readSpoolField(data); err != nil {
+			return nil, err
+		}
+
+		message.Headers = append(message.Headers, header)
+	}
+
+	return message, nil
+}
+
+func appendSpoolField(buf, field []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(field)))
+	return append(buf, field...)
+}
+
+func readSpoolField(data []byte) ([]byte, []byte, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return nil, nil, errors.New("malformed field")
+	}
+
+	return data[n : n+int(size)], data[n+int(size):], nil
+}
+
+// replaySpool periodically drains the spool back into Kafka.
+func (g *Gateway) replaySpool() {
+	ticker := time.NewTicker(g.config.Spool.ReplayInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		g.replaySpoolSegments()
+	}
+}
+
+// replaySpoolSegments replays segments oldest first while the circuit breakers of their topics are not open.
+// The segment is removed only after all of its messages are acknowledged, otherwise it is replayed again
+// on the next run.
+func (g *Gateway) replaySpoolSegments() {
+	for {
+		segment, ok := g.spool.oldest()
+		if !ok {
+			return
+		}
+
+		if time.Since(segment.created) > g.config.Spool.MaxAge {
+			log.Printf("dropping expired spool segment %s", segment.path)
+
+			metricSpoolExpiredBytes.Add(float64(segment.size))
+
+			if err := g.spool.remove(segment); err != nil {
+				log.Printf("failed to remove spool segment: %v", err)
+				return
+			}
+
+			continue
+		}
+
+		messages, err := g.spool.read(segment)
+		if err != nil {
+			log.Printf("failed to replay spool segment: %v", err)
+			return
+		}
+
+		if g.breakers.check(messages) != nil {
+			return
+		}
+
+		ack := &produceAck{}
+
+		for _, message := range messages {
+			// the segment is kept until every message of it is produced, produced messages are replayed again
+			if err := g.produce(message, ack); err != nil {
+				log.Printf("failed to replay spool segment %s, will retry: %v", segment.path, err)
+				return
+			}
+		}
+
+		if err := ack.wait(g.getKafkaWriteTimeout()); err != nil {
+			log.Printf("failed to replay spool segment %s, will retry: %v", segment.path, err)
+			return
+		}
+
+		if err := g.spool.remove(segment); err != nil {
+			log.Printf("failed to remove spool segment: %v", err)
+			return
+		}
+
+		metricSpoolReplayedMessages.Add(float64(len(messages)))
+	}
+}
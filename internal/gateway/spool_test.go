@@ -0,0 +1,184 @@
+package gateway
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSpool(t *testing.T, config SpoolConfig) *spool {
+	t.Helper()
+
+	config.Path = t.TempDir()
+	config.setDefaults()
+
+	s, err := newSpool(config)
+	require.NoError(t, err)
+
+	return s
+}
+
+func newTestSpoolMessage(value string) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic: "metrics",
+		Key:   sarama.StringEncoder("key-" + value),
+		Value: sarama.StringEncoder(value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("header"), Value: []byte(value)},
+		},
+	}
+}
+
+func encodeTestMessage(t *testing.T, message *sarama.ProducerMessage) []byte {
+	t.Helper()
+
+	data, err := encodeSpoolMessage(message)
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestSpool(t *testing.T) {
+	t.Run("messages survive reopen", func(t *testing.T) {
+		s := newTestSpool(t, SpoolConfig{})
+
+		messages := []*sarama.ProducerMessage{newTestSpoolMessage("first"), newTestSpoolMessage("second")}
+		require.NoError(t, s.write(messages))
+		require.NoError(t, s.Close())
+
+		reopened, err := newSpool(s.config)
+		require.NoError(t, err)
+
+		segment, ok := reopened.oldest()
+		require.True(t, ok)
+
+		got, err := reopened.read(segment)
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+
+		for idx, message := range got {
+			assert.Equal(t, encodeTestMessage(t, messages[idx]), encodeTestMessage(t, message))
+		}
+
+		require.NoError(t, reopened.remove(segment))
+
+		_, ok = reopened.oldest()
+		assert.False(t, ok)
+		assert.Equal(t, int64(0), reopened.totalSize)
+	})
+
+	t.Run("segments are rotated by size", func(t *testing.T) {
+		s := newTestSpool(t, SpoolConfig{MaxSegmentSize: 64})
+
+		for _, value := range []string{"first", "second", "third"} {
+			require.NoError(t, s.write([]*sarama.ProducerMessage{newTestSpoolMessage(value)}))
+		}
+
+		assert.Len(t, s.segments, 2)
+	})
+
+	t.Run("spool is full", func(t *testing.T) {
+		s := newTestSpool(t, SpoolConfig{MaxSize: 64})
+
+		require.NoError(t, s.write([]*sarama.ProducerMessage{newTestSpoolMessage("first")}))
+		assert.ErrorIs(t, s.write([]*sarama.ProducerMessage{newTestSpoolMessage("second")}), errSpoolFull)
+	})
+
+	t.Run("torn record is skipped", func(t *testing.T) {
+		s := newTestSpool(t, SpoolConfig{})
+
+		require.NoError(t, s.write([]*sarama.ProducerMessage{newTestSpoolMessage("first")}))
+
+		segment, ok := s.oldest()
+		require.True(t, ok)
+
+		file, err := os.OpenFile(segment.path, os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = file.Write([]byte{0, 0, 0, 10, 1, 2})
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		got, err := s.read(segment)
+		require.NoError(t, err)
+		assert.Len(t, got, 1)
+	})
+
+	for _, tt := range []struct {
+		name string
+		// offset of the corrupted byte within the second record
+		offset int
+	}{
+		{name: "corrupted payload is skipped", offset: spoolRecordHeaderSize + 2},
+		{name: "corrupted length is skipped", offset: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSpool(t, SpoolConfig{})
+
+			messages := []*sarama.ProducerMessage{newTestSpoolMessage("first"), newTestSpoolMessage("second"), newTestSpoolMessage("third")}
+			require.NoError(t, s.write(messages))
+			require.NoError(t, s.Close())
+
+			segment, ok := s.oldest()
+			require.True(t, ok)
+
+			data, err := os.ReadFile(segment.path)
+			require.NoError(t, err)
+
+			data[spoolRecordHeaderSize+len(encodeTestMessage(t, messages[0]))+tt.offset] ^= 0xff
+			require.NoError(t, os.WriteFile(segment.path, data, 0o600))
+
+			corrupted := testutil.ToFloat64(metricSpoolCorruptedRecords)
+
+			got, err := s.read(segment)
+			require.NoError(t, err)
+			require.Len(t, got, 2)
+
+			// records after the corrupted one are kept
+			assert.Equal(t, sarama.ByteEncoder("first"), got[0].Value)
+			assert.Equal(t, sarama.ByteEncoder("third"), got[1].Value)
+			assert.Equal(t, 1.0, testutil.ToFloat64(metricSpoolCorruptedRecords)-corrupted)
+		})
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	g, producer := newTestGateway(t, &Config{
+		Kafka: KafkaConfig{Topic: "metrics"},
+		Spool: SpoolConfig{Path: t.TempDir()},
+	})
+
+	// kafka has failed recently, so the request is written to the spool
+	setTestBreakerState(g.breakers.get("metrics"), breakerOpen)
+
+	w := serveTestWrite(g, newTestWriteRequest(t, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			},
+		},
+	}))
+
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Positive(t, g.spool.totalSize)
+
+	// kafka is available again
+	setTestBreakerState(g.breakers.get("metrics"), breakerClosed)
+
+	producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "metrics", msg.Topic)
+		return nil
+	})
+
+	g.replaySpoolSegments()
+
+	assert.Equal(t, int64(0), g.spool.totalSize)
+	assert.Empty(t, g.spool.segments)
+	assert.NoError(t, producer.Close())
+}
@@ -0,0 +1,524 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	statsdCounter      = "c"
+	statsdGauge        = "g"
+	statsdTimer        = "ms"
+	statsdHistogram    = "h"
+	statsdDistribution = "d"
+	statsdSet          = "s"
+)
+
+// errStatsDUnsupported reports valid StatsD lines the gateway does not aggregate: sets, events and service checks.
+var errStatsDUnsupported = errors.New("unsupported statsd line")
+
+// StatsDConfig is a listener of StatsD and DogStatsD packets over UDP. Received metrics are aggregated
+// and their series are published at every flush.
+type StatsDConfig struct {
+	Name      string `yaml:"name"`
+	ListenUDP string `yaml:"listen_udp"`
+	// Topic receives series of the listener instead of kafka.topic, routes still apply.
+	Topic  string `yaml:"topic"`
+	Tenant string `yaml:"tenant"`
+	// FlushInterval is the period series updated since the previous flush are published at.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// SeriesTTL forgets series not updated for the duration, their counters start over when they come back.
+	SeriesTTL time.Duration `yaml:"series_ttl"`
+	// Buckets are the upper bounds of histograms of timers, histograms and distributions.
+	// Timers are observed in seconds.
+	Buckets []float64 `yaml:"buckets"`
+	// Mappings convert metric names to Prometheus names and labels, evaluated in order.
+	Mappings []*Mapping `yaml:"mappings"`
+}
+
+func (c *StatsDConfig) setDefaults() {
+	if c.FlushInterval == 0 {
+		c.FlushInterval = 10 * time.Second
+	}
+
+	if c.SeriesTTL == 0 {
+		c.SeriesTTL = 5 * time.Minute
+	}
+
+	if len(c.Buckets) == 0 {
+		c.Buckets = prometheus.DefBuckets
+	}
+}
+
+func (c *StatsDConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("statsd listener name is required")
+	}
+
+	if c.ListenUDP == "" {
+		return errors.New("statsd listener requires listen_udp")
+	}
+
+	if c.FlushInterval < 0 || c.SeriesTTL < 0 {
+		return errors.New("statsd listener settings must not be negative")
+	}
+
+	for idx := 1; idx < len(c.Buckets); idx++ {
+		if c.Buckets[idx] <= c.Buckets[idx-1] {
+			return errors.New("statsd listener buckets must be in increasing order")
+		}
+	}
+
+	if err := validateTenant(c.Tenant); err != nil {
+		return err
+	}
+
+	for idx, mapping := range c.Mappings {
+		if err := mapping.compile(); err != nil {
+			return fmt.Errorf("invalid mapping %d: %w", idx, err)
+		}
+	}
+
+	return nil
+}
+
+// user returns the user the series of the listener are published as.
+func (c *StatsDConfig) user() *User {
+	user := &User{Tenant: c.Tenant}
+	if c.Topic != "" {
+		user.Topic = &c.Topic
+	}
+
+	return user
+}
+
+// statsdMetric is a parsed StatsD line.
+type statsdMetric struct {
+	name       string
+	metricType string
+	values     []float64
+	// relative gauge values are added to the current value
+	relative   []bool
+	sampleRate float64
+	tags       []prompb.Label
+}
+
+// parseStatsDLine parses the "name:value[:value...]|type[|@sample_rate][|#tag:value,...]" line. Multiple values
+// and tags are DogStatsD extensions, other DogStatsD fields such as the container ID are ignored.
+func parseStatsDLine(line string) (statsdMetric, error) {
+	if strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+		return statsdMetric{}, errStatsDUnsupported
+	}
+
+	fields := strings.Split(line, "|")
+	if len(fields) < 2 {
+		return statsdMetric{}, fmt.Errorf("missing metric type: %q", line)
+	}
+
+	name, rawValues, ok := strings.Cut(fields[0], ":")
+	if !ok || name == "" || rawValues == "" {
+		return statsdMetric{}, fmt.Errorf("expected name and value: %q", line)
+	}
+
+	metric := statsdMetric{
+		name:       name,
+		metricType: fields[1],
+		sampleRate: 1,
+	}
+
+	switch metric.metricType {
+	case statsdCounter, statsdGauge, statsdTimer, statsdHistogram, statsdDistribution:
+
+	case statsdSet:
+		return statsdMetric{}, errStatsDUnsupported
+
+	default:
+		return statsdMetric{}, fmt.Errorf("unknown metric type %q", metric.metricType)
+	}
+
+	for _, rawValue := range strings.Split(rawValues, ":") {
+		value, err := strconv.ParseFloat(rawValue, 64)
+		if err != nil {
+			return statsdMetric{}, fmt.Errorf("invalid value %q: %w", rawValue, err)
+		}
+
+		metric.values = append(metric.values, value)
+		metric.relative = append(metric.relative, rawValue[0] == '+' || rawValue[0] == '-')
+	}
+
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return statsdMetric{}, fmt.Errorf("invalid sample rate %q", field)
+			}
+
+			metric.sampleRate = rate
+
+		case strings.HasPrefix(field, "#"):
+			for _, tag := range strings.Split(field[1:], ",") {
+				tagName, value, ok := strings.Cut(tag, ":")
+				if !ok || tagName == "" || value == "" {
+					// tags without a value have no label representation
+					continue
+				}
+
+				metric.tags = append(metric.tags, prompb.Label{Name: sanitizeLabelName(tagName), Value: value})
+			}
+		}
+	}
+
+	return metric, nil
+}
+
+// statsdSeries is the aggregate of a series. Counters keep the total since the series appeared,
+// gauges keep the last value and histograms keep cumulative bucket counts.
+type statsdSeries struct {
+	metricType string
+	labels     []prompb.Label
+
+	value float64
+
+	bucketCounts []float64
+	count        float64
+	sum          float64
+
+	updated time.Time
+	// updates counts updates of the series, published is the count at the last published flush,
+	// so series updated while the flush is published are published again at the next flush
+	updates   uint64
+	published uint64
+}
+
+// statsdFlushed are update counts of the flushed series, they are published once the series are.
+type statsdFlushed map[*statsdSeries]uint64
+
+// statsdAggregator aggregates received metrics to series.
+type statsdAggregator struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*statsdSeries
+}
+
+func newStatsDAggregator(buckets []float64) *statsdAggregator {
+	return &statsdAggregator{
+		buckets: buckets,
+		series:  make(map[string]*statsdSeries),
+	}
+}
+
+// add aggregates values of the metric to the series with the given labels. It returns false when
+// the series is already aggregated as another type, the values are not added in this case.
+func (a *statsdAggregator) add(metric statsdMetric, labels []prompb.Label, now time.Time) bool {
+	// timers, histograms and distributions are aggregated to the same kind of series
+	metricType := metric.metricType
+	if metricType == statsdTimer || metricType == statsdDistribution {
+		metricType = statsdHistogram
+	}
+
+	var key strings.Builder
+
+	for _, label := range labels {
+		key.WriteByte(0xff)
+		key.WriteString(label.Name)
+		key.WriteByte(0xff)
+		key.WriteString(label.Value)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	series, ok := a.series[key.String()]
+	if !ok {
+		series = &statsdSeries{metricType: metricType, labels: labels}
+		if metricType == statsdHistogram {
+			series.bucketCounts = make([]float64, len(a.buckets))
+		}
+
+		a.series[key.String()] = series
+	} else if series.metricType != metricType {
+		return false
+	}
+
+	series.updated = now
+	series.updates++
+
+	for idx, value := range metric.values {
+		switch metricType {
+		case statsdCounter:
+			series.value += value / metric.sampleRate
+
+		case statsdGauge:
+			if metric.relative[idx] {
+				series.value += value
+			} else {
+				series.value = value
+			}
+
+		case statsdHistogram:
+			if metric.metricType == statsdTimer {
+				value /= 1000
+			}
+
+			weight := 1 / metric.sampleRate
+
+			series.count += weight
+			series.sum += value * weight
+
+			for bucketIdx, bound := range a.buckets {
+				if value <= bound {
+					series.bucketCounts[bucketIdx] += weight
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// flush returns series updated since the previous published flush with samples at the given time,
+// series not updated for the ttl are forgotten. The series are published once the flushed ones are committed.
+func (a *statsdAggregator) flush(now time.Time, ttl time.Duration) ([]prompb.TimeSeries, statsdFlushed) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var result []prompb.TimeSeries
+
+	flushed := make(statsdFlushed)
+
+	timestamp := now.UnixMilli()
+
+	for key, series := range a.series {
+		if now.Sub(series.updated) > ttl {
+			delete(a.series, key)
+			continue
+		}
+
+		if series.updates == series.published {
+			continue
+		}
+
+		flushed[series] = series.updates
+
+		if series.metricType != statsdHistogram {
+			result = append(result, prompb.TimeSeries{
+				Labels:  series.labels,
+				Samples: []prompb.Sample{{Value: series.value, Timestamp: timestamp}},
+			})
+
+			continue
+		}
+
+		name := getMetricName(series.labels)
+
+		for idx, bound := range a.buckets {
+			result = append(result, newStatsDHistogramSeries(series.labels, name+"_bucket",
+				strconv.FormatFloat(bound, 'f', -1, 64), series.bucketCounts[idx], timestamp))
+		}
+
+		result = append(result,
+			newStatsDHistogramSeries(series.labels, name+"_bucket", "+Inf", series.count, timestamp),
+			newStatsDHistogramSeries(series.labels, name+"_sum", "", series.sum, timestamp),
+			newStatsDHistogramSeries(series.labels, name+"_count", "", series.count, timestamp),
+		)
+	}
+
+	return result, flushed
+}
+
+// commit marks the flushed series published.
+func (a *statsdAggregator) commit(flushed statsdFlushed) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for series, updates := range flushed {
+		series.published = updates
+	}
+}
+
+// size returns the number of aggregated series.
+func (a *statsdAggregator) size() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.series)
+}
+
+// newStatsDHistogramSeries returns the series of the histogram with the given name and the le label when set.
+func newStatsDHistogramSeries(labels []prompb.Label, name, le string, value float64, timestamp int64) prompb.TimeSeries {
+	result := make([]prompb.Label, 0, len(labels)+1)
+
+	for _, label := range labels {
+		if label.Name == model.MetricNameLabel {
+			label.Value = name
+		}
+
+		result = append(result, label)
+	}
+
+	if le != "" {
+		result = append(result, prompb.Label{Name: model.BucketLabel, Value: le})
+		slices.SortFunc(result, func(a, b prompb.Label) int { return strings.Compare(a.Name, b.Name) })
+	}
+
+	return prompb.TimeSeries{
+		Labels:  result,
+		Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+	}
+}
+
+// statsdListener receives StatsD packets and publishes the aggregated series at every flush.
+type statsdListener struct {
+	gateway    *Gateway
+	config     *StatsDConfig
+	user       *User
+	aggregator *statsdAggregator
+
+	udp net.PacketConn
+
+	read    chan struct{}
+	done    chan struct{}
+	flushed chan struct{}
+}
+
+// startStatsDListener opens the socket of the listener and starts receiving packets.
+func (g *Gateway) startStatsDListener(config *StatsDConfig) (*statsdListener, error) {
+	udp, err := net.ListenPacket("udp", config.ListenUDP)
+	if err != nil {
+		return nil, fmt.Errorf("statsd listener %s: %w", config.Name, err)
+	}
+
+	l := &statsdListener{
+		gateway:    g,
+		config:     config,
+		user:       config.user(),
+		aggregator: newStatsDAggregator(config.Buckets),
+		udp:        udp,
+		read:       make(chan struct{}),
+		done:       make(chan struct{}),
+		flushed:    make(chan struct{}),
+	}
+
+	go l.readUDP()
+	go l.flushLoop()
+
+	return l, nil
+}
+
+// close stops receiving packets and publishes the pending series.
+func (l *statsdListener) close() {
+	l.udp.Close()
+	<-l.read
+
+	close(l.done)
+	<-l.flushed
+}
+
+func (l *statsdListener) readUDP() {
+	defer close(l.read)
+
+	buf := make([]byte, maxUDPPacketSize)
+
+	for {
+		size, _, err := l.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Printf("statsd listener %s: error reading packet: %v", l.config.Name, err)
+
+			continue
+		}
+
+		now := time.Now()
+
+		for _, line := range strings.Split(string(buf[:size]), "\n") {
+			l.handleLine(line, now)
+		}
+	}
+}
+
+func (l *statsdListener) handleLine(line string, now time.Time) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	metricStatsDReceivedLines.WithLabelValues(l.config.Name).Inc()
+
+	metric, err := parseStatsDLine(line)
+	if err != nil {
+		if errors.Is(err, errStatsDUnsupported) {
+			metricStatsDDroppedSeries.WithLabelValues(l.config.Name, "unsupported").Inc()
+		} else {
+			metricStatsDInvalidLines.WithLabelValues(l.config.Name).Inc()
+		}
+
+		return
+	}
+
+	name, mapped, keep := mapPath(l.config.Mappings, metric.name)
+	if !keep {
+		metricStatsDDroppedSeries.WithLabelValues(l.config.Name, "mapping").Inc()
+		return
+	}
+
+	if !l.aggregator.add(metric, buildMappedSeriesLabels(name, metric.tags, mapped), now) {
+		metricStatsDDroppedSeries.WithLabelValues(l.config.Name, "type_conflict").Inc()
+	}
+}
+
+// flushLoop publishes the aggregated series every flush interval and once more when the listener is closed.
+func (l *statsdListener) flushLoop() {
+	defer close(l.flushed)
+
+	ticker := time.NewTicker(l.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.flush(time.Now())
+
+		case <-l.done:
+			l.flush(time.Now())
+			return
+		}
+	}
+}
+
+func (l *statsdListener) flush(now time.Time) {
+	series, flushed := l.aggregator.flush(now, l.config.SeriesTTL)
+
+	metricStatsDSeries.WithLabelValues(l.config.Name).Set(float64(l.aggregator.size()))
+
+	if len(series) == 0 {
+		return
+	}
+
+	if err := l.gateway.publishSeries(l.user, "statsd", now, series); err != nil {
+		// the series are not committed, so they are published again at the next flush
+		log.Printf("statsd listener %s: error publishing %d series: %v", l.config.Name, len(series), err)
+		metricStatsDDroppedSeries.WithLabelValues(l.config.Name, "publish").Add(float64(len(series)))
+
+		return
+	}
+
+	l.aggregator.commit(flushed)
+
+	metricStatsDPublishedSeries.WithLabelValues(l.config.Name).Add(float64(len(series)))
+}
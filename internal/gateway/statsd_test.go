@@ -0,0 +1,321 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatsDLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		metric statsdMetric
+	}{
+		{
+			name:   "counter",
+			line:   "api.requests:1|c",
+			metric: statsdMetric{name: "api.requests", metricType: "c", values: []float64{1}, relative: []bool{false}, sampleRate: 1},
+		},
+		{
+			name:   "relative gauge",
+			line:   "queue.size:-3|g",
+			metric: statsdMetric{name: "queue.size", metricType: "g", values: []float64{-3}, relative: []bool{true}, sampleRate: 1},
+		},
+		{
+			name: "dogstatsd",
+			line: "api.latency:120:80|ms|@0.5|#env:prod,canary,service.name:checkout|c:abc123",
+			metric: statsdMetric{
+				name:       "api.latency",
+				metricType: "ms",
+				values:     []float64{120, 80},
+				relative:   []bool{false, false},
+				sampleRate: 0.5,
+				tags: []prompb.Label{
+					{Name: "env", Value: "prod"},
+					{Name: "service_name", Value: "checkout"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, err := parseStatsDLine(tt.line)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.metric, metric)
+		})
+	}
+
+	for _, line := range []string{
+		"api.requests",
+		"api.requests:1",
+		":1|c",
+		"api.requests:x|c",
+		"api.requests:1|x",
+		"api.requests:1|c|@0",
+		"api.requests:1|c|@x",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parseStatsDLine(line)
+			assert.Error(t, err)
+			assert.NotErrorIs(t, err, errStatsDUnsupported)
+		})
+	}
+
+	for _, line := range []string{
+		"users.unique:42|s",
+		"_e{5,4}:title|text",
+		"_sc|redis.can_connect|0",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parseStatsDLine(line)
+			assert.ErrorIs(t, err, errStatsDUnsupported)
+		})
+	}
+}
+
+// addTestStatsDLine parses the line and adds it to the aggregator with the metric name sanitized.
+func addTestStatsDLine(t *testing.T, aggregator *statsdAggregator, line string, now time.Time) {
+	t.Helper()
+
+	metric, err := parseStatsDLine(line)
+	require.NoError(t, err)
+
+	require.True(t, aggregator.add(metric, buildMappedSeriesLabels(sanitizeMetricName(metric.name), metric.tags, nil), now))
+}
+
+// formatTestSeries formats series as sorted "labels value" strings.
+func formatTestSeries(series []prompb.TimeSeries) []string {
+	result := make([]string, 0, len(series))
+
+	for _, ts := range series {
+		labels := make([]string, 0, len(ts.Labels))
+		for _, label := range ts.Labels {
+			labels = append(labels, label.Name+"="+label.Value)
+		}
+
+		result = append(result, fmt.Sprintf("{%s} %v", strings.Join(labels, ","), ts.Samples[0].Value))
+	}
+
+	slices.Sort(result)
+
+	return result
+}
+
+// flushTestStatsD flushes the aggregator and commits the series as published.
+func flushTestStatsD(aggregator *statsdAggregator, now time.Time) []prompb.TimeSeries {
+	series, flushed := aggregator.flush(now, time.Minute)
+	aggregator.commit(flushed)
+
+	return series
+}
+
+func TestStatsDAggregator(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+
+	aggregator := newStatsDAggregator([]float64{0.1, 1})
+
+	addTestStatsDLine(t, aggregator, "requests:1|c|#env:prod", now)
+	addTestStatsDLine(t, aggregator, "requests:2|c|@0.5|#env:prod", now)
+	addTestStatsDLine(t, aggregator, "queue:10|g", now)
+	addTestStatsDLine(t, aggregator, "queue:-3|g", now)
+	addTestStatsDLine(t, aggregator, "latency:50:500|ms", now)
+	addTestStatsDLine(t, aggregator, "latency:2000|ms", now)
+
+	series := flushTestStatsD(aggregator, now)
+
+	for _, ts := range series {
+		assert.Equal(t, []prompb.Sample{{Value: ts.Samples[0].Value, Timestamp: 1_700_000_000_000}}, ts.Samples)
+	}
+
+	assert.Equal(t, []string{
+		"{__name__=latency_bucket,le=+Inf} 3",
+		"{__name__=latency_bucket,le=0.1} 1",
+		"{__name__=latency_bucket,le=1} 2",
+		"{__name__=latency_count} 3",
+		"{__name__=latency_sum} 2.55",
+		"{__name__=queue} 7",
+		"{__name__=requests,env=prod} 5",
+	}, formatTestSeries(series))
+
+	// lines of another type than the aggregated series are dropped
+	metric, err := parseStatsDLine("queue:1|c")
+	require.NoError(t, err)
+	assert.False(t, aggregator.add(metric, buildMappedSeriesLabels("queue", nil, nil), now))
+
+	// timers and histograms are aggregated to the same series
+	addTestStatsDLine(t, aggregator, "latency:0.45|h", now)
+
+	assert.Equal(t, []string{
+		"{__name__=latency_bucket,le=+Inf} 4",
+		"{__name__=latency_bucket,le=0.1} 1",
+		"{__name__=latency_bucket,le=1} 3",
+		"{__name__=latency_count} 4",
+		"{__name__=latency_sum} 3",
+	}, formatTestSeries(flushTestStatsD(aggregator, now)))
+
+	// only updated series are published, counters keep the total
+	addTestStatsDLine(t, aggregator, "requests:1|c|#env:prod", now.Add(time.Second))
+
+	assert.Equal(t, []string{
+		"{__name__=requests,env=prod} 6",
+	}, formatTestSeries(flushTestStatsD(aggregator, now.Add(time.Second))))
+
+	// series of a flush failed to publish are flushed again, with updates received meanwhile
+	addTestStatsDLine(t, aggregator, "queue:1|g", now.Add(time.Second))
+	_, _ = aggregator.flush(now.Add(time.Second), time.Minute)
+
+	series, flushed := aggregator.flush(now.Add(time.Second), time.Minute)
+	assert.Equal(t, []string{"{__name__=queue} 1"}, formatTestSeries(series))
+
+	addTestStatsDLine(t, aggregator, "queue:2|g", now.Add(time.Second))
+	aggregator.commit(flushed)
+
+	assert.Equal(t, []string{
+		"{__name__=queue} 2",
+	}, formatTestSeries(flushTestStatsD(aggregator, now.Add(time.Second))))
+
+	// series not updated for the ttl are forgotten
+	assert.Empty(t, flushTestStatsD(aggregator, now.Add(2*time.Minute)))
+	assert.Equal(t, 0, aggregator.size())
+}
+
+func TestStatsDListener(t *testing.T) {
+	config := &StatsDConfig{
+		Name:      "test-listener",
+		ListenUDP: "127.0.0.1:0",
+		Topic:     "statsd",
+		// series are published when the listener is closed
+		FlushInterval: time.Hour,
+		Mappings: []*Mapping{
+			{Match: "api.*.requests", Name: "api_requests_total", Labels: map[string]string{"handler": "${1}"}},
+			{Match: "debug.*", Action: mappingActionDrop},
+		},
+	}
+	config.setDefaults()
+	require.NoError(t, config.validate())
+
+	g, producer := newTestGateway(t, &Config{Kafka: KafkaConfig{Topic: "metrics"}, StatsD: []*StatsDConfig{config}})
+
+	var series []prompb.TimeSeries
+
+	for range 2 {
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			assert.Equal(t, "statsd", msg.Topic)
+			assert.Equal(t, "statsd", parseTestEnvelope(t, msg).Protocol)
+
+			value, err := msg.Value.Encode()
+			if err != nil {
+				return err
+			}
+
+			ts := prompb.TimeSeries{}
+			if err := proto.Unmarshal(value, &ts); err != nil {
+				return err
+			}
+
+			series = append(series, ts)
+
+			return nil
+		})
+	}
+
+	received := testutil.ToFloat64(metricStatsDReceivedLines.WithLabelValues("test-listener"))
+	invalid := testutil.ToFloat64(metricStatsDInvalidLines.WithLabelValues("test-listener"))
+	dropped := testutil.ToFloat64(metricStatsDDroppedSeries.WithLabelValues("test-listener", "mapping"))
+
+	listener, err := g.startStatsDListener(config)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("udp", listener.udp.LocalAddr().String())
+	require.NoError(t, err)
+
+	_, err = fmt.Fprint(conn, "api.users.requests:1|c|#env:prod\napi.users.requests:2|c|#env:prod\nnot valid\n")
+	require.NoError(t, err)
+
+	_, err = fmt.Fprint(conn, "debug.gc:1|c\nmemory.used:1024|g")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metricStatsDReceivedLines.WithLabelValues("test-listener"))-received == 5
+	}, 5*time.Second, 10*time.Millisecond)
+
+	listener.close()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricStatsDInvalidLines.WithLabelValues("test-listener"))-invalid)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricStatsDDroppedSeries.WithLabelValues("test-listener", "mapping"))-dropped)
+	require.NoError(t, producer.Close())
+
+	// the message checker runs on the producer goroutine until it is closed
+	assert.Equal(t, []string{
+		"{__name__=api_requests_total,env=prod,handler=users} 3",
+		"{__name__=memory_used} 1024",
+	}, formatTestSeries(series))
+}
+
+func TestStatsDConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		statsd string
+	}{
+		{
+			name: "missing name",
+			statsd: `
+  - listen_udp: ":8125"`,
+		},
+		{
+			name: "missing listen address",
+			statsd: `
+  - name: a`,
+		},
+		{
+			name: "unsorted buckets",
+			statsd: `
+  - name: a
+    listen_udp: ":8125"
+    buckets: [1, 0.5]`,
+		},
+		{
+			name: "invalid mapping",
+			statsd: `
+  - name: a
+    listen_udp: ":8125"
+    mappings:
+      - match: "api.*"
+        action: keep`,
+		},
+		{
+			name: "duplicate name",
+			statsd: `
+  - name: a
+    listen_udp: ":8125"
+  - name: a
+    listen_udp: ":8126"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte("statsd:"+tt.statsd+"\n"), 0o600))
+
+			_, err := loadConfig(path)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tenantHeader is the tenant header used by Cortex, Mimir and Loki.
+const tenantHeader = "X-Scope-OrgID"
+
+// tenantRegexp allows the characters Cortex allows in tenant IDs and a colon for
+// VictoriaMetrics cluster "accountID:projectID" tenants.
+var tenantRegexp = regexp.MustCompile(`^[a-zA-Z0-9!\-_.*'():]{1,150}$`)
+
+// tenantMiddleware resolves the tenant of the write request. The tenant of the user is used,
+// unless the user is allowed to take it from the X-Scope-OrgID header and the header is sent.
+func tenantMiddleware(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	tenant := user.Tenant
+
+	if value := c.GetHeader(tenantHeader); value != "" && user.TenantFromHeader {
+		tenant = value
+	}
+
+	if err := validateTenant(tenant); err != nil {
+		c.String(http.StatusBadRequest, "%v", err)
+		c.Abort()
+		return
+	}
+
+	c.Set("tenant", tenant)
+	c.Next()
+}
+
+// validateTenant checks the tenant ID, the empty one means no tenant.
+func validateTenant(tenant string) error {
+	if tenant != "" && !tenantRegexp.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant: %s", tenant)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       *User
+		header     string
+		wantStatus int
+		wantTenant string
+	}{
+		{
+			name:       "tenant of the user",
+			user:       &User{Tenant: "team-a"},
+			wantStatus: http.StatusOK,
+			wantTenant: "team-a",
+		},
+		{
+			name:       "header is ignored when not allowed",
+			user:       &User{Tenant: "team-a"},
+			header:     "team-b",
+			wantStatus: http.StatusOK,
+			wantTenant: "team-a",
+		},
+		{
+			name:       "tenant from header",
+			user:       &User{Tenant: "team-a", TenantFromHeader: true},
+			header:     "42:7",
+			wantStatus: http.StatusOK,
+			wantTenant: "42:7",
+		},
+		{
+			name:       "tenant of the user without header",
+			user:       &User{Tenant: "team-a", TenantFromHeader: true},
+			wantStatus: http.StatusOK,
+			wantTenant: "team-a",
+		},
+		{
+			name:       "invalid tenant from header",
+			user:       &User{TenantFromHeader: true},
+			header:     "../admin",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+
+			var tenant string
+
+			r := gin.New()
+			r.POST("/", func(c *gin.Context) { c.Set("user", tt.user) }, tenantMiddleware, func(c *gin.Context) {
+				tenant = c.GetString("tenant")
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tenantHeader, tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantTenant, tenant)
+		})
+	}
+}
+
+func TestBuildMessagesTenant(t *testing.T) {
+	g := &Gateway{config: &Config{Kafka: KafkaConfig{Topic: "metrics"}}}
+
+	user := &User{ExternalLabels: map[string]string{"cluster": "prod"}}
+
+	messages, _, err := g.buildMessages(user, envelope.Envelope{Tenant: "42"}, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "spoofed"}}},
+		},
+		Metadata: []prompb.MetricMetadata{{MetricFamilyName: "up"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+
+	for _, message := range messages {
+		assert.Equal(t, "42", parseTestEnvelope(t, message).Tenant)
+	}
+
+	value, err := messages[0].Value.Encode()
+	require.NoError(t, err)
+
+	var series prompb.TimeSeries
+	require.NoError(t, series.Unmarshal(value))
+
+	assert.Equal(t, []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "prod"}}, series.Labels)
+}
+
+func TestUserValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		user User
+	}{
+		{name: "invalid tenant", user: User{Tenant: "a/b"}},
+		{name: "metric name external label", user: User{ExternalLabels: map[string]string{"__name__": "up"}}},
+		{name: "empty external label name", user: User{ExternalLabels: map[string]string{"": "value"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.user.validate())
+		})
+	}
+
+	assert.NoError(t, (&User{Tenant: "0:1", ExternalLabels: map[string]string{"team": "a"}}).validate())
+}
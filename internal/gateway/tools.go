@@ -1,6 +1,11 @@
 package gateway
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+
 	"github.com/golang/snappy"
 	"github.com/klauspost/compress/zstd"
 )
@@ -28,3 +33,23 @@ func decompressZSTD(compressed []byte) ([]byte, error) {
 
 	return decompressed, nil
 }
+
+// decompressGzip decompresses the body up to maxInsertRequestSize.
+func decompressGzip(compressed []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxInsertRequestSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decompressed) > maxInsertRequestSize {
+		return nil, errors.New("decompressed body is too large")
+	}
+
+	return decompressed, nil
+}
@@ -1,6 +1,8 @@
 package gateway
 
 import (
+	"bytes"
+	"compress/gzip"
 	"testing"
 
 	"github.com/golang/snappy"
@@ -49,3 +51,30 @@ func TestDecompressZSTD(t *testing.T) {
 		t.Fatalf("expected error, got nil")
 	}
 }
+
+func TestDecompressGzip(t *testing.T) {
+	// Test case: Valid gzip compressed data
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write([]byte("test data")); err != nil {
+		t.Fatalf("failed to write gzip data: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+
+	decompressed, err := decompressGzip(compressed.Bytes())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if string(decompressed) != "test data" {
+		t.Fatalf("expected 'test data', got %s", decompressed)
+	}
+
+	// Test case: Invalid gzip compressed data
+	invalidCompressed := []byte{0x00, 0x01, 0x02}
+	_, err = decompressGzip(invalidCompressed)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
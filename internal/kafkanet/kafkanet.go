@@ -0,0 +1,118 @@
+// Package kafkanet configures TLS and SASL of Kafka connections shared by the gateway and the worker.
+package kafkanet
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/common/config"
+	"github.com/xdg-go/scram"
+)
+
+const (
+	MechanismPlain       = "PLAIN"
+	MechanismSCRAMSHA256 = "SCRAM-SHA-256"
+	MechanismSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// Config is TLS and SASL settings of Kafka connections.
+type Config struct {
+	// TLS enables TLS when set, the system CA pool is used without the CA file.
+	TLS  *config.TLSConfig `yaml:"tls"`
+	SASL SASLConfig        `yaml:"sasl"`
+}
+
+// SASLConfig enables SASL authentication when the mechanism is set.
+type SASLConfig struct {
+	Mechanism    string        `yaml:"mechanism"`
+	Username     string        `yaml:"username"`
+	Password     config.Secret `yaml:"password"`
+	PasswordFile string        `yaml:"password_file"`
+}
+
+// Apply sets TLS and SASL settings of the sarama config, reading certificates and the password file.
+func (c *Config) Apply(kafkaConf *sarama.Config) error {
+	if c.TLS != nil {
+		tlsConfig, err := config.NewTLSConfig(c.TLS)
+		if err != nil {
+			return fmt.Errorf("invalid kafka tls config: %w", err)
+		}
+
+		kafkaConf.Net.TLS.Enable = true
+		kafkaConf.Net.TLS.Config = tlsConfig
+	}
+
+	if c.SASL.Mechanism == "" {
+		return nil
+	}
+
+	password := string(c.SASL.Password)
+
+	if c.SASL.PasswordFile != "" {
+		if password != "" {
+			return fmt.Errorf("kafka sasl password and password_file are mutually exclusive")
+		}
+
+		data, err := os.ReadFile(c.SASL.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read kafka sasl password file: %w", err)
+		}
+
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+
+	kafkaConf.Net.SASL.Enable = true
+	kafkaConf.Net.SASL.Handshake = true
+	kafkaConf.Net.SASL.Version = sarama.SASLHandshakeV1
+	kafkaConf.Net.SASL.User = c.SASL.Username
+	kafkaConf.Net.SASL.Password = password
+
+	switch c.SASL.Mechanism {
+	case MechanismPlain:
+		kafkaConf.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+
+	case MechanismSCRAMSHA256:
+		kafkaConf.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		kafkaConf.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGenerator: scram.SHA256}
+		}
+
+	case MechanismSCRAMSHA512:
+		kafkaConf.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		kafkaConf.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGenerator: scram.SHA512}
+		}
+
+	default:
+		return fmt.Errorf("unknown kafka sasl mechanism: %s", c.SASL.Mechanism)
+	}
+
+	return nil
+}
+
+// scramClient implements sarama.SCRAMClient.
+type scramClient struct {
+	hashGenerator scram.HashGeneratorFcn
+	conversation  *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	c.conversation = client.NewConversation()
+
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
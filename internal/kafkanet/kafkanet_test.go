@@ -0,0 +1,188 @@
+package kafkanet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/common/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xdg-go/scram"
+)
+
+// newTestCertificate returns a self-signed certificate for localhost and writes it to the CA file.
+func newTestCertificate(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+func newTestClient(t *testing.T, addr string, netConfig *Config) (sarama.Client, error) {
+	t.Helper()
+
+	kafkaConf := sarama.NewConfig()
+	kafkaConf.Version = sarama.V2_8_0_0
+	kafkaConf.Metadata.Retry.Max = 0
+	kafkaConf.Net.DialTimeout = time.Second
+
+	require.NoError(t, netConfig.Apply(kafkaConf))
+	require.NoError(t, kafkaConf.Validate())
+
+	return sarama.NewClient([]string{addr}, kafkaConf)
+}
+
+func TestApplyTLS(t *testing.T) {
+	cert, caFile := newTestCertificate(t)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+
+	broker := sarama.NewMockBrokerListener(t, 1, listener)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+
+	t.Run("trusted CA", func(t *testing.T) {
+		client, err := newTestClient(t, broker.Addr(), &Config{TLS: &config.TLSConfig{CAFile: caFile}})
+		require.NoError(t, err)
+
+		assert.NoError(t, client.Close())
+	})
+
+	t.Run("missing CA file", func(t *testing.T) {
+		err := (&Config{TLS: &config.TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}}).Apply(sarama.NewConfig())
+		assert.Error(t, err)
+	})
+}
+
+func TestApplySASLPlain(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest":      sarama.NewMockApiVersionsResponse(t),
+		"SaslHandshakeRequest":    sarama.NewMockSaslHandshakeResponse(t).SetEnabledMechanisms([]string{sarama.SASLTypePlaintext}),
+		"SaslAuthenticateRequest": sarama.NewMockSaslAuthenticateResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0o600))
+
+	client, err := newTestClient(t, broker.Addr(), &Config{SASL: SASLConfig{
+		Mechanism:    MechanismPlain,
+		Username:     "gateway",
+		PasswordFile: passwordFile,
+	}})
+	require.NoError(t, err)
+	defer client.Close()
+
+	var authBytes []byte
+	for _, row := range broker.History() {
+		if req, ok := row.Request.(*sarama.SaslAuthenticateRequest); ok {
+			authBytes = req.SaslAuthBytes
+		}
+	}
+
+	assert.Equal(t, "\x00gateway\x00secret", string(authBytes))
+}
+
+func TestApplySASLValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		sasl SASLConfig
+	}{
+		{name: "unknown mechanism", sasl: SASLConfig{Mechanism: "GSSAPI"}},
+		{name: "password and password file", sasl: SASLConfig{Mechanism: MechanismPlain, Password: "a", PasswordFile: "b"}},
+		{name: "missing password file", sasl: SASLConfig{Mechanism: MechanismPlain, PasswordFile: filepath.Join(t.TempDir(), "missing")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, (&Config{SASL: tt.sasl}).Apply(sarama.NewConfig()))
+		})
+	}
+}
+
+func TestSCRAMClient(t *testing.T) {
+	for _, mechanism := range []string{MechanismSCRAMSHA256, MechanismSCRAMSHA512} {
+		t.Run(mechanism, func(t *testing.T) {
+			kafkaConf := sarama.NewConfig()
+			require.NoError(t, (&Config{SASL: SASLConfig{Mechanism: mechanism, Username: "worker", Password: "secret"}}).Apply(kafkaConf))
+
+			hashGenerator := scram.SHA256
+			if mechanism == MechanismSCRAMSHA512 {
+				hashGenerator = scram.SHA512
+			}
+
+			client, err := hashGenerator.NewClient("worker", "secret", "")
+			require.NoError(t, err)
+
+			credentials := client.GetStoredCredentials(scram.KeyFactors{Salt: "salt", Iters: 4096})
+
+			server, err := hashGenerator.NewServer(func(string) (scram.StoredCredentials, error) {
+				return credentials, nil
+			})
+			require.NoError(t, err)
+
+			conversation := server.NewConversation()
+
+			scramClient := kafkaConf.Net.SASL.SCRAMClientGeneratorFunc()
+			require.NoError(t, scramClient.Begin(kafkaConf.Net.SASL.User, kafkaConf.Net.SASL.Password, ""))
+
+			var challenge string
+			for !scramClient.Done() {
+				response, err := scramClient.Step(challenge)
+				require.NoError(t, err)
+
+				if scramClient.Done() {
+					break
+				}
+
+				challenge, err = conversation.Step(response)
+				require.NoError(t, err)
+			}
+
+			assert.True(t, conversation.Valid())
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	promconfig "github.com/prometheus/common/config"
+)
+
+type Worker struct {
+	config   *Config
+	client   sarama.ConsumerGroup
+	consumer *Consumer
+}
+
+func New(configPath string) (*Worker, error) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+
+	kafkaConf, err := newSaramaConfig(config.Kafka)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.Kafka.Net.Apply(kafkaConf); err != nil {
+		return nil, err
+	}
+
+	client, err := sarama.NewConsumerGroup(config.Kafka.Brokers, config.Kafka.ConsumerGroup.GroupID, kafkaConf)
+	if err != nil {
+		return nil, fmt.Errorf("error creating consumer group: %w", err)
+	}
+
+	destinations := make([]*destination, 0, len(config.RemoteWrite))
+
+	for _, remoteWrite := range config.RemoteWrite {
+		d, err := newDestination(remoteWrite)
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("error creating remote write client of %s: %w", remoteWrite.Name, err)
+		}
+
+		destinations = append(destinations, d)
+	}
+
+	consumer := NewConsumer(destinations...)
+
+	consumer.batchLen = config.Batch.MaxMessages
+	consumer.batchSize = config.Batch.MaxBytes
+	consumer.batchTime = config.Batch.MaxWait
+	consumer.flushTimeout = config.Batch.FlushTimeout
+
+	consumer.backoff = backoff{minDelay: config.Retry.MinBackoff, maxDelay: config.Retry.MaxBackoff}
+
+	if config.Kafka.DeadLetterTopic != "" {
+		producerConf := sarama.NewConfig()
+		producerConf.ClientID = kafkaConf.ClientID
+		producerConf.Version = kafkaConf.Version
+		producerConf.Producer.RequiredAcks = sarama.WaitForAll
+		producerConf.Producer.Return.Successes = true
+
+		if err := config.Kafka.Net.Apply(producerConf); err != nil {
+			client.Close()
+			return nil, err
+		}
+
+		producer, err := sarama.NewSyncProducer(config.Kafka.Brokers, producerConf)
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("error creating dead-letter producer: %w", err)
+		}
+
+		consumer.deadLetterTopic = config.Kafka.DeadLetterTopic
+		consumer.deadLetterProducer = producer
+	}
+
+	return &Worker{
+		config:   config,
+		client:   client,
+		consumer: consumer,
+	}, nil
+}
+
+// newSaramaConfig returns the consumer group config of the worker validated by sarama,
+// without TLS and SASL which read certificates and secrets from files.
+func newSaramaConfig(config KafkaConfig) (*sarama.Config, error) {
+	kafkaConf := sarama.NewConfig()
+	kafkaConf.ClientID = config.ClientID
+	kafkaConf.Consumer.Return.Errors = true
+
+	version, err := sarama.ParseKafkaVersion(config.Version)
+	if err != nil {
+		return nil, err
+	}
+	kafkaConf.Version = version
+
+	switch config.ConsumerGroup.InitialOffset {
+	case "oldest":
+		kafkaConf.Consumer.Offsets.Initial = sarama.OffsetOldest
+	case "newest":
+		kafkaConf.Consumer.Offsets.Initial = sarama.OffsetNewest
+	default:
+		return nil, fmt.Errorf("unknown initial_offset: %s", config.ConsumerGroup.InitialOffset)
+	}
+
+	switch config.ConsumerGroup.RebalanceStrategy {
+	case "range":
+		kafkaConf.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRange()}
+	case "roundrobin":
+		kafkaConf.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRoundRobin()}
+	case "sticky":
+		kafkaConf.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategySticky()}
+	default:
+		return nil, fmt.Errorf("unknown rebalance_strategy: %s", config.ConsumerGroup.RebalanceStrategy)
+	}
+
+	if config.ConsumerGroup.SessionTimeout > 0 {
+		kafkaConf.Consumer.Group.Session.Timeout = config.ConsumerGroup.SessionTimeout
+	}
+
+	if config.ConsumerGroup.HeartbeatInterval > 0 {
+		kafkaConf.Consumer.Group.Heartbeat.Interval = config.ConsumerGroup.HeartbeatInterval
+	}
+
+	if err := kafkaConf.Validate(); err != nil {
+		return nil, err
+	}
+
+	return kafkaConf, nil
+}
+
+// newRemoteWriteClient returns the HTTP client of the destination.
+func newRemoteWriteClient(config *RemoteWriteConfig) (*http.Client, error) {
+	httpClient, err := promconfig.NewClientFromConfig(config.httpClientConfig(), "remote_write")
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient.Timeout = config.Timeout
+
+	return httpClient, nil
+}
+
+func (w *Worker) Run() error {
+	defer w.client.Close()
+
+	if w.consumer.deadLetterProducer != nil {
+		defer w.consumer.deadLetterProducer.Close()
+	}
+
+	if w.config.MetricsListen != "" {
+		go w.listenMetrics()
+	}
+
+	go func() {
+		for err := range w.client.Errors() {
+			log.Printf("consumer group error: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for {
+			if err := w.client.Consume(ctx, w.config.Kafka.Topics, w.consumer); err != nil {
+				log.Printf("error consuming: %v", err)
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			w.consumer.ready = make(chan bool)
+		}
+	}()
+	<-w.consumer.ready
+
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+	<-sigterm
+
+	log.Println("shutdown worker ...")
+
+	// the session flushes pending batches and commits their offsets before Consume returns
+	cancel()
+	wg.Wait()
+
+	w.consumer.Close()
+
+	return nil
+}
+
+func (w *Worker) listenMetrics() {
+	router := http.NewServeMux()
+	router.Handle("/metrics", promhttp.Handler())
+
+	router.HandleFunc("/-/healthy", healthyHandler)
+	router.HandleFunc("/-/ready", w.consumer.readyHandler)
+
+	router.HandleFunc("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	router.HandleFunc("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	router.HandleFunc("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	router.HandleFunc("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	router.HandleFunc("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+
+	if err := http.ListenAndServe(w.config.MetricsListen, router); err != nil {
+		log.Fatalf("error starting metrics server: %v", err)
+	}
+}
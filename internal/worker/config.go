@@ -0,0 +1,440 @@
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	promconfig "github.com/prometheus/common/config"
+	"gopkg.in/yaml.v3"
+
+	"github.com/vitalvas/prometheus-mimic/internal/kafkanet"
+)
+
+const (
+	defaultRemoteWriteURL = "http://victoriametrics:8428/api/v1/write"
+
+	// defaultRetryMaxDuration bounds the outage of a destination which stalls the other destinations.
+	defaultRetryMaxDuration = 5 * time.Minute
+)
+
+type Config struct {
+	Kafka KafkaConfig `yaml:"kafka"`
+	Batch BatchConfig `yaml:"batch"`
+	// Retry is the default retry policy of destinations and of the dead-letter producer.
+	Retry RetryConfig `yaml:"retry"`
+	// RemoteWrite lists destinations every batch is delivered to.
+	RemoteWrite []*RemoteWriteConfig `yaml:"remote_write"`
+
+	MetricsListen string `yaml:"metrics_listen"`
+}
+
+type KafkaConfig struct {
+	Topics   []string `yaml:"topics"`
+	Brokers  []string `yaml:"brokers"`
+	ClientID string   `yaml:"client_id"`
+	Version  string   `yaml:"version"`
+
+	// Net configures TLS and SASL of connections to brokers.
+	Net kafkanet.Config `yaml:",inline"`
+
+	ConsumerGroup ConsumerGroupConfig `yaml:"consumer_group"`
+
+	// DeadLetterTopic receives undecodable and permanently rejected messages, they are dropped when it is empty.
+	DeadLetterTopic string `yaml:"dead_letter_topic"`
+}
+
+type ConsumerGroupConfig struct {
+	GroupID string `yaml:"group_id"`
+	// InitialOffset is used for partitions without a committed offset: oldest or newest.
+	InitialOffset     string        `yaml:"initial_offset"`
+	RebalanceStrategy string        `yaml:"rebalance_strategy"`
+	SessionTimeout    time.Duration `yaml:"session_timeout"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+}
+
+// BatchConfig bounds batches of messages sent to the remote endpoint in a single request.
+type BatchConfig struct {
+	MaxMessages int           `yaml:"max_messages"`
+	MaxBytes    int           `yaml:"max_bytes"`
+	MaxWait     time.Duration `yaml:"max_wait"`
+	// FlushTimeout bounds delivery of the pending batch when the claim is revoked or the worker stops.
+	FlushTimeout time.Duration `yaml:"flush_timeout"`
+}
+
+// RetryConfig is the exponential backoff of recoverable delivery failures.
+type RetryConfig struct {
+	MinBackoff time.Duration `yaml:"min_backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	// MaxDuration gives up on batches once the destination has been failing for the duration, their messages
+	// are sent to the dead-letter topic. Explicit zero retries until the batch is delivered.
+	// It does not apply to the dead-letter producer, which always retries.
+	MaxDuration *time.Duration `yaml:"max_duration"`
+}
+
+// maxDuration returns the max duration of retries, zero without the limit.
+func (c *RetryConfig) maxDuration() time.Duration {
+	if c.MaxDuration == nil {
+		return 0
+	}
+
+	return *c.MaxDuration
+}
+
+type RemoteWriteConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// DefaultTenant is used in the write endpoint for messages without a tenant.
+	DefaultTenant string        `yaml:"default_tenant"`
+	Timeout       time.Duration `yaml:"timeout"`
+
+	// At most one of BasicAuth, Authorization, BearerToken and BearerTokenFile is set,
+	// credential files are read on every request.
+	BasicAuth       *promconfig.BasicAuth     `yaml:"basic_auth"`
+	Authorization   *promconfig.Authorization `yaml:"authorization"`
+	BearerToken     promconfig.Secret         `yaml:"bearer_token"`
+	BearerTokenFile string                    `yaml:"bearer_token_file"`
+	TLSConfig       promconfig.TLSConfig      `yaml:"tls_config"`
+	// Headers are added to every request, they cannot override headers set by the worker.
+	Headers map[string]string `yaml:"headers"`
+
+	Queue QueueConfig `yaml:"queue"`
+	// Retry overrides the default retry policy for the destination.
+	Retry RetryConfig `yaml:"retry"`
+}
+
+// QueueConfig configures the queue of batches waiting for delivery to the destination.
+type QueueConfig struct {
+	// Concurrency is the number of batches delivered to the destination at the same time. The queue is split
+	// into as many shards by partition, so batches of a partition are delivered in order.
+	Concurrency int `yaml:"concurrency"`
+	// Capacity is the number of batches waiting in every shard of the queue.
+	Capacity int `yaml:"capacity"`
+	// FullPolicy is applied to batches which do not fit the queue: block or drop. Drop sends messages
+	// of the batch to the dead-letter topic, without it they are lost for the destination.
+	FullPolicy string `yaml:"full_policy"`
+}
+
+func (c *RemoteWriteConfig) setDefaults(retry RetryConfig) {
+	if c.DefaultTenant == "" {
+		c.DefaultTenant = "0"
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 30 * time.Second
+	}
+
+	if c.Queue.Concurrency == 0 {
+		c.Queue.Concurrency = 4
+	}
+
+	if c.Queue.Capacity == 0 {
+		c.Queue.Capacity = 4
+	}
+
+	if c.Queue.FullPolicy == "" {
+		c.Queue.FullPolicy = queueFullBlock
+	}
+
+	if c.Retry.MinBackoff == 0 {
+		c.Retry.MinBackoff = retry.MinBackoff
+	}
+
+	if c.Retry.MaxBackoff == 0 {
+		c.Retry.MaxBackoff = retry.MaxBackoff
+	}
+
+	if c.Retry.MaxDuration == nil {
+		c.Retry.MaxDuration = retry.MaxDuration
+	}
+}
+
+func (c *RemoteWriteConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("name is required for multiple destinations")
+	}
+
+	if _, err := url.ParseRequestURI(c.URL); err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+
+	httpConfig := c.httpClientConfig()
+	if err := httpConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid http client config: %w", err)
+	}
+
+	for name := range c.Headers {
+		if slices.ContainsFunc(reservedHeaders, func(reserved string) bool { return strings.EqualFold(reserved, name) }) {
+			return fmt.Errorf("header %s is set by the worker and cannot be overridden", name)
+		}
+	}
+
+	if c.Queue.Concurrency < 0 || c.Queue.Capacity < 0 {
+		return errors.New("queue concurrency and capacity must not be negative")
+	}
+
+	if c.Queue.FullPolicy != queueFullBlock && c.Queue.FullPolicy != queueFullDrop {
+		return fmt.Errorf("unknown queue full_policy: %s", c.Queue.FullPolicy)
+	}
+
+	return c.Retry.validate()
+}
+
+// httpClientConfig returns the HTTP client settings of the destination.
+func (c *RemoteWriteConfig) httpClientConfig() promconfig.HTTPClientConfig {
+	return promconfig.HTTPClientConfig{
+		BasicAuth:       c.BasicAuth,
+		Authorization:   c.Authorization,
+		BearerToken:     c.BearerToken,
+		BearerTokenFile: c.BearerTokenFile,
+		TLSConfig:       c.TLSConfig,
+		FollowRedirects: true,
+		EnableHTTP2:     true,
+	}
+}
+
+func (c *RetryConfig) validate() error {
+	if c.MinBackoff < 0 || c.MinBackoff > c.MaxBackoff {
+		return fmt.Errorf("retry min_backoff %s must be between zero and max_backoff %s", c.MinBackoff, c.MaxBackoff)
+	}
+
+	if c.maxDuration() < 0 {
+		return errors.New("retry max_duration must not be negative")
+	}
+
+	return nil
+}
+
+func (c *Config) setDefaults() {
+	if len(c.Kafka.Topics) == 0 {
+		c.Kafka.Topics = []string{"vmcluster_default"}
+	}
+
+	if len(c.Kafka.Brokers) == 0 {
+		c.Kafka.Brokers = []string{"kafka:9092"}
+	}
+
+	if c.Kafka.ClientID == "" {
+		c.Kafka.ClientID = "mimic-worker"
+	}
+
+	if c.Kafka.Version == "" {
+		c.Kafka.Version = "2.8.0"
+	}
+
+	if c.Kafka.ConsumerGroup.GroupID == "" {
+		c.Kafka.ConsumerGroup.GroupID = "prometheus-mimic-worker"
+	}
+
+	if c.Kafka.ConsumerGroup.InitialOffset == "" {
+		c.Kafka.ConsumerGroup.InitialOffset = "oldest"
+	}
+
+	if c.Kafka.ConsumerGroup.RebalanceStrategy == "" {
+		c.Kafka.ConsumerGroup.RebalanceStrategy = "range"
+	}
+
+	if c.Batch.MaxMessages == 0 {
+		c.Batch.MaxMessages = 100_000
+	}
+
+	if c.Batch.MaxBytes == 0 {
+		c.Batch.MaxBytes = 30 * 1024 * 1024 // 30MB, no more than maxInsertRequestSize (victoria-metrics)
+	}
+
+	if c.Batch.MaxWait == 0 {
+		c.Batch.MaxWait = time.Second
+	}
+
+	if c.Batch.FlushTimeout == 0 {
+		c.Batch.FlushTimeout = 10 * time.Second
+	}
+
+	if c.Retry.MinBackoff == 0 {
+		c.Retry.MinBackoff = time.Second
+	}
+
+	if c.Retry.MaxBackoff == 0 {
+		c.Retry.MaxBackoff = 30 * time.Second
+	}
+
+	if c.Retry.MaxDuration == nil {
+		maxDuration := defaultRetryMaxDuration
+		c.Retry.MaxDuration = &maxDuration
+	}
+
+	if len(c.RemoteWrite) == 0 {
+		c.RemoteWrite = []*RemoteWriteConfig{{URL: defaultRemoteWriteURL}}
+	}
+
+	if len(c.RemoteWrite) == 1 && c.RemoteWrite[0].Name == "" {
+		c.RemoteWrite[0].Name = "default"
+	}
+
+	for _, remoteWrite := range c.RemoteWrite {
+		remoteWrite.setDefaults(c.Retry)
+	}
+}
+
+func (c *Config) validate() error {
+	if c.Batch.MaxMessages < 0 || c.Batch.MaxBytes < 0 || c.Batch.MaxWait < 0 || c.Batch.FlushTimeout < 0 {
+		return errors.New("batch settings must not be negative")
+	}
+
+	if err := c.Retry.validate(); err != nil {
+		return err
+	}
+
+	names := make(map[string]struct{}, len(c.RemoteWrite))
+
+	for idx, remoteWrite := range c.RemoteWrite {
+		if err := remoteWrite.validate(); err != nil {
+			return fmt.Errorf("invalid remote_write %d: %w", idx, err)
+		}
+
+		if _, ok := names[remoteWrite.Name]; ok {
+			return fmt.Errorf("duplicate remote_write name: %s", remoteWrite.Name)
+		}
+
+		names[remoteWrite.Name] = struct{}{}
+	}
+
+	if _, err := newSaramaConfig(c.Kafka); err != nil {
+		return fmt.Errorf("invalid kafka config: %w", err)
+	}
+
+	return nil
+}
+
+// loadConfig reads the config file when the filename is set, applies MIMIC_* environment variables
+// on top of it and fills in the defaults.
+func loadConfig(filename string) (*Config, error) {
+	config := &Config{}
+
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		if err := decoder.Decode(config); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal yaml config: %w", err)
+		}
+	}
+
+	if err := config.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	config.setDefaults()
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// loadEnv overrides the config with the environment variables which are set.
+func (c *Config) loadEnv() error {
+	if row, ok := os.LookupEnv("MIMIC_KAFKA_TOPICS"); ok {
+		c.Kafka.Topics = strings.Split(row, ",")
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_KAFKA_BROKERS"); ok {
+		c.Kafka.Brokers = strings.Split(row, ",")
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_KAFKA_GROUP_ID"); ok {
+		c.Kafka.ConsumerGroup.GroupID = row
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_WRITE_ENDPOINT"); ok {
+		c.firstRemoteWrite().URL = row
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_METRICS_LISTEN"); ok {
+		c.MetricsListen = row
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_DEFAULT_TENANT"); ok {
+		c.firstRemoteWrite()
+
+		for _, remoteWrite := range c.RemoteWrite {
+			remoteWrite.DefaultTenant = row
+		}
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_KAFKA_DEAD_LETTER_TOPIC"); ok {
+		c.Kafka.DeadLetterTopic = row
+	}
+
+	tlsConfig := &promconfig.TLSConfig{
+		CAFile:     os.Getenv("MIMIC_KAFKA_TLS_CA_FILE"),
+		CertFile:   os.Getenv("MIMIC_KAFKA_TLS_CERT_FILE"),
+		KeyFile:    os.Getenv("MIMIC_KAFKA_TLS_KEY_FILE"),
+		ServerName: os.Getenv("MIMIC_KAFKA_TLS_SERVER_NAME"),
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_KAFKA_TLS_INSECURE_SKIP_VERIFY"); ok {
+		value, err := strconv.ParseBool(row)
+		if err != nil {
+			return fmt.Errorf("invalid MIMIC_KAFKA_TLS_INSECURE_SKIP_VERIFY: %w", err)
+		}
+
+		tlsConfig.InsecureSkipVerify = value
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_KAFKA_TLS_ENABLED"); ok {
+		enabled, err := strconv.ParseBool(row)
+		if err != nil {
+			return fmt.Errorf("invalid MIMIC_KAFKA_TLS_ENABLED: %w", err)
+		}
+
+		c.Kafka.Net.TLS = nil
+		if enabled {
+			c.Kafka.Net.TLS = tlsConfig
+		}
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_KAFKA_SASL_MECHANISM"); ok {
+		c.Kafka.Net.SASL.Mechanism = row
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_KAFKA_SASL_USERNAME"); ok {
+		c.Kafka.Net.SASL.Username = row
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_KAFKA_SASL_PASSWORD"); ok {
+		c.Kafka.Net.SASL.Password = promconfig.Secret(row)
+	}
+
+	if row, ok := os.LookupEnv("MIMIC_KAFKA_SASL_PASSWORD_FILE"); ok {
+		c.Kafka.Net.SASL.PasswordFile = row
+	}
+
+	return nil
+}
+
+// firstRemoteWrite returns the destination the environment variables apply to,
+// adding the default one when none is configured.
+func (c *Config) firstRemoteWrite() *RemoteWriteConfig {
+	if len(c.RemoteWrite) == 0 {
+		c.RemoteWrite = []*RemoteWriteConfig{{URL: defaultRemoteWriteURL}}
+	}
+
+	return c.RemoteWrite[0]
+}
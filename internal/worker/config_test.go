@@ -0,0 +1,174 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vitalvas/prometheus-mimic/internal/kafkanet"
+)
+
+func TestLoadConfigKafkaNet(t *testing.T) {
+	t.Run("disabled by default", func(t *testing.T) {
+		config, err := loadConfig("")
+		require.NoError(t, err)
+
+		assert.Nil(t, config.Kafka.Net.TLS)
+		assert.Empty(t, config.Kafka.Net.SASL.Mechanism)
+	})
+
+	t.Run("tls and sasl", func(t *testing.T) {
+		t.Setenv("MIMIC_KAFKA_TLS_ENABLED", "true")
+		t.Setenv("MIMIC_KAFKA_TLS_CA_FILE", "/etc/kafka/ca.pem")
+		t.Setenv("MIMIC_KAFKA_SASL_MECHANISM", kafkanet.MechanismSCRAMSHA256)
+		t.Setenv("MIMIC_KAFKA_SASL_USERNAME", "worker")
+		t.Setenv("MIMIC_KAFKA_SASL_PASSWORD_FILE", "/etc/kafka/password")
+
+		config, err := loadConfig("")
+		require.NoError(t, err)
+
+		require.NotNil(t, config.Kafka.Net.TLS)
+		assert.Equal(t, "/etc/kafka/ca.pem", config.Kafka.Net.TLS.CAFile)
+		assert.Equal(t, kafkanet.SASLConfig{
+			Mechanism:    kafkanet.MechanismSCRAMSHA256,
+			Username:     "worker",
+			PasswordFile: "/etc/kafka/password",
+		}, config.Kafka.Net.SASL)
+	})
+
+	t.Run("invalid boolean", func(t *testing.T) {
+		t.Setenv("MIMIC_KAFKA_TLS_ENABLED", "maybe")
+
+		_, err := loadConfig("")
+		assert.Error(t, err)
+	})
+}
+
+func writeTestConfig(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		config, err := loadConfig("")
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"vmcluster_default"}, config.Kafka.Topics)
+		assert.Equal(t, []string{"kafka:9092"}, config.Kafka.Brokers)
+		assert.Equal(t, "prometheus-mimic-worker", config.Kafka.ConsumerGroup.GroupID)
+		require.Len(t, config.RemoteWrite, 1)
+		assert.Equal(t, "default", config.RemoteWrite[0].Name)
+		assert.Equal(t, "http://victoriametrics:8428/api/v1/write", config.RemoteWrite[0].URL)
+		assert.Equal(t, "0", config.RemoteWrite[0].DefaultTenant)
+		assert.Equal(t, queueFullBlock, config.RemoteWrite[0].Queue.FullPolicy)
+		assert.Equal(t, 100_000, config.Batch.MaxMessages)
+		assert.Equal(t, time.Second, config.Retry.MinBackoff)
+		assert.Equal(t, defaultRetryMaxDuration, config.RemoteWrite[0].Retry.maxDuration())
+	})
+
+	t.Run("zero max duration retries without limit", func(t *testing.T) {
+		config, err := loadConfig(writeTestConfig(t, "retry:\n  max_duration: 0s\n"))
+		require.NoError(t, err)
+
+		assert.Zero(t, config.RemoteWrite[0].Retry.maxDuration())
+	})
+
+	t.Run("file with env overrides", func(t *testing.T) {
+		path := writeTestConfig(t, `
+kafka:
+  topics: [metrics, histograms]
+  brokers: [kafka-1:9092]
+  version: 3.6.0
+  consumer_group:
+    group_id: worker-file
+    initial_offset: newest
+    rebalance_strategy: sticky
+batch:
+  max_messages: 5000
+  max_wait: 5s
+retry:
+  max_backoff: 1m
+  max_duration: 10m
+remote_write:
+  - name: primary
+    url: http://vminsert:8480/insert/{tenant}/prometheus/api/v1/write
+    basic_auth:
+      username: worker
+      password: secret
+  - name: staging
+    url: http://prometheus-staging:9090/api/v1/write
+    authorization:
+      credentials_file: /etc/mimic/token
+    tls_config:
+      insecure_skip_verify: true
+    headers:
+      X-Source: mimic
+    queue:
+      capacity: 2
+      full_policy: drop
+    retry:
+      min_backoff: 5s
+metrics_listen: :9090
+`)
+
+		t.Setenv("MIMIC_KAFKA_GROUP_ID", "worker-env")
+
+		config, err := loadConfig(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"metrics", "histograms"}, config.Kafka.Topics)
+		assert.Equal(t, "worker-env", config.Kafka.ConsumerGroup.GroupID)
+		assert.Equal(t, "newest", config.Kafka.ConsumerGroup.InitialOffset)
+		assert.Equal(t, 5000, config.Batch.MaxMessages)
+		assert.Equal(t, 5*time.Second, config.Batch.MaxWait)
+		assert.Equal(t, time.Minute, config.Retry.MaxBackoff)
+		require.Len(t, config.RemoteWrite, 2)
+		assert.Equal(t, "worker", config.RemoteWrite[0].BasicAuth.Username)
+		assert.Equal(t, time.Second, config.RemoteWrite[0].Retry.MinBackoff)
+		assert.Equal(t, "/etc/mimic/token", config.RemoteWrite[1].Authorization.CredentialsFile)
+		assert.True(t, config.RemoteWrite[1].TLSConfig.InsecureSkipVerify)
+		assert.Equal(t, map[string]string{"X-Source": "mimic"}, config.RemoteWrite[1].Headers)
+		assert.Equal(t, QueueConfig{Concurrency: 4, Capacity: 2, FullPolicy: queueFullDrop}, config.RemoteWrite[1].Queue)
+		maxDuration := 10 * time.Minute
+		assert.Equal(t, RetryConfig{MinBackoff: 5 * time.Second, MaxBackoff: time.Minute, MaxDuration: &maxDuration}, config.RemoteWrite[1].Retry)
+		assert.Equal(t, ":9090", config.MetricsListen)
+
+		kafkaConf, err := newSaramaConfig(config.Kafka)
+		require.NoError(t, err)
+		assert.Equal(t, "mimic-worker", kafkaConf.ClientID)
+	})
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "unknown field", config: "kafka:\n  topic: metrics\n"},
+		{name: "unknown version", config: "kafka:\n  version: 0.1.2\n"},
+		{name: "unknown initial offset", config: "kafka:\n  consumer_group:\n    initial_offset: latest\n"},
+		{name: "unknown rebalance strategy", config: "kafka:\n  consumer_group:\n    rebalance_strategy: random\n"},
+		{name: "min backoff above max", config: "retry:\n  min_backoff: 1m\n  max_backoff: 1s\n"},
+		{name: "negative max duration", config: "retry:\n  max_duration: -1s\n"},
+		{name: "invalid url", config: "remote_write:\n  - url: victoriametrics\n"},
+		{name: "unnamed destinations", config: "remote_write:\n  - url: http://a/write\n  - url: http://b/write\n"},
+		{name: "duplicate names", config: "remote_write:\n  - name: a\n    url: http://a/write\n  - name: a\n    url: http://b/write\n"},
+		{name: "unknown full policy", config: "remote_write:\n  - url: http://a/write\n    queue:\n      full_policy: wait\n"},
+		{name: "multiple credentials", config: "remote_write:\n  - url: http://a/write\n    basic_auth:\n      username: worker\n    bearer_token: token\n"},
+		{name: "reserved header", config: "remote_write:\n  - url: http://a/write\n    headers:\n      content-type: text/plain\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeTestConfig(t, tt.config))
+			assert.Error(t, err)
+		})
+	}
+}
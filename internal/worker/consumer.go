@@ -0,0 +1,402 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
+)
+
+const (
+	// tenantPlaceholder in the write endpoint is replaced with the tenant of the messages,
+	// for example, http://vminsert:8480/insert/{tenant}/prometheus/api/v1/write
+	tenantPlaceholder = "{tenant}"
+
+	// tenantHeader is sent with the tenant of the messages for Cortex and Mimir style endpoints.
+	tenantHeader = "X-Scope-OrgID"
+)
+
+// NewConsumer returns the consumer delivering batches to the destinations and starts their delivery goroutines.
+func NewConsumer(destinations ...*destination) *Consumer {
+	consumer := &Consumer{
+		ready: make(chan bool),
+
+		destinations: destinations,
+
+		batchLen:  100_000,
+		batchSize: 30 * 1024 * 1024, // 30MB, no more than maxInsertRequestSize (victoria-metrics)
+		batchTime: time.Second,
+
+		backoff:      backoff{minDelay: time.Second, maxDelay: 30 * time.Second},
+		flushTimeout: 10 * time.Second,
+	}
+
+	for _, d := range destinations {
+		d.sendDeadLetter = consumer.sendDeadLetter
+		d.start()
+	}
+
+	return consumer
+}
+
+type Consumer struct {
+	ready chan bool
+
+	// member is set while the consumer is a member of the group with claimed partitions
+	member atomic.Bool
+
+	destinations []*destination
+
+	batchLen  int
+	batchSize int
+	batchTime time.Duration
+
+	// backoff is used for producing to the dead-letter topic
+	backoff backoff
+	// flushTimeout bounds delivery of the pending batch when the claim is revoked or the worker stops
+	flushTimeout time.Duration
+
+	deadLetterTopic    string
+	deadLetterProducer sarama.SyncProducer
+}
+
+func (consumer *Consumer) Setup(sarama.ConsumerGroupSession) error {
+	consumer.member.Store(true)
+	close(consumer.ready)
+	return nil
+}
+
+func (consumer *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
+	consumer.member.Store(false)
+	return nil
+}
+
+// Close stops delivery goroutines of the destinations, it must be called after consumption is stopped.
+func (consumer *Consumer) Close() {
+	for _, d := range consumer.destinations {
+		d.close()
+	}
+}
+
+// ConsumeClaim batches messages of the partition and dispatches batches to all destinations.
+// Offsets of a batch are marked only after every destination completed it and all previous batches
+// of the partition, so unsent messages are consumed again after a crash or rebalance.
+func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	messages := make([]*sarama.ConsumerMessage, 0, consumer.batchLen)
+	var messagesSize int
+
+	var inflight []*batch
+	defer func() {
+		for _, b := range inflight {
+			b.cancel()
+		}
+	}()
+
+	dispatch := func() error {
+		b, err := consumer.dispatch(session.Context(), messages)
+		if err != nil {
+			return fmt.Errorf("batch is not delivered, it will be consumed again: %w", err)
+		}
+
+		inflight = append(inflight, b)
+
+		messages = messages[:0]
+		messagesSize = 0
+
+		return nil
+	}
+
+	batchTicker := time.NewTicker(consumer.batchTime)
+	defer batchTicker.Stop()
+
+	// the partition is claimed by another member after the rebalance
+	defer metricConsumerLag.DeleteLabelValues(claim.Topic(), strconv.FormatInt(int64(claim.Partition()), 10))
+
+	for {
+		var completed <-chan struct{}
+		if len(inflight) > 0 {
+			completed = inflight[0].done
+		}
+
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				// the claim is revoked by rebalance or the worker is shutting down
+				return consumer.flushPending(session, messages, inflight)
+			}
+
+			if msg == nil {
+				continue // ignore nil messages
+			}
+
+			observeConsumed(claim, msg)
+
+			messagesSize += len(msg.Value)
+			messages = append(messages, msg)
+
+			if len(messages) >= consumer.batchLen || messagesSize >= consumer.batchSize {
+				if err := dispatch(); err != nil {
+					return err
+				}
+
+				batchTicker.Reset(consumer.batchTime)
+			}
+
+		case <-batchTicker.C:
+			if len(messages) > 0 {
+				if err := dispatch(); err != nil {
+					return err
+				}
+			}
+
+		case <-completed:
+			var err error
+			if inflight, err = markCompleted(session, inflight); err != nil {
+				return err
+			}
+
+		case <-session.Context().Done():
+			return consumer.flushPending(session, messages, inflight)
+		}
+	}
+}
+
+// markCompleted marks offsets of the completed batches at the head of inflight and returns the rest.
+func markCompleted(session sarama.ConsumerGroupSession, inflight []*batch) ([]*batch, error) {
+	for len(inflight) > 0 {
+		b := inflight[0]
+
+		select {
+		case <-b.done:
+		default:
+			return inflight, nil
+		}
+
+		if err := b.result(); err != nil {
+			return inflight, fmt.Errorf("batch is not delivered, it will be consumed again: %w", err)
+		}
+
+		session.MarkMessage(b.last, "")
+		b.cancel()
+
+		inflight = inflight[1:]
+	}
+
+	return inflight, nil
+}
+
+// flushPending dispatches the last batch of the claim and waits for all inflight batches within flushTimeout,
+// as the session context is already done.
+func (consumer *Consumer) flushPending(session sarama.ConsumerGroupSession, messages []*sarama.ConsumerMessage, inflight []*batch) error {
+	ctx, cancel := context.WithTimeout(context.Background(), consumer.flushTimeout)
+	defer cancel()
+
+	if len(messages) > 0 {
+		b, err := consumer.dispatch(ctx, messages)
+		if err != nil {
+			return fmt.Errorf("batch is not delivered, it will be consumed again: %w", err)
+		}
+
+		defer b.cancel()
+
+		inflight = append(inflight, b)
+	}
+
+	for _, b := range inflight {
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return fmt.Errorf("batch is not delivered, it will be consumed again: %w", ctx.Err())
+		}
+
+		if err := b.result(); err != nil {
+			return fmt.Errorf("batch is not delivered, it will be consumed again: %w", err)
+		}
+
+		session.MarkMessage(b.last, "")
+	}
+
+	return nil
+}
+
+// batch is a set of consumed messages of a partition delivered to every destination.
+type batch struct {
+	// ctx is canceled when the batch is abandoned, to stop its delivery
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	tenants  []string
+	messages map[string][]decodedMessage
+	// last is the message with the highest offset, its offset is marked when the batch is completed
+	last *sarama.ConsumerMessage
+
+	mu      sync.Mutex
+	pending int
+	err     error
+	done    chan struct{}
+}
+
+// finish completes the batch for a destination, done is closed when all destinations completed it.
+func (b *batch) finish(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+
+	b.pending--
+	if b.pending == 0 {
+		close(b.done)
+	}
+}
+
+// result returns the first delivery error of the batch.
+func (b *batch) result() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.err
+}
+
+// decodedMessage is a consumed message with its decoded payload.
+type decodedMessage struct {
+	msg      *sarama.ConsumerMessage
+	meta     envelope.Envelope
+	series   []prompb.TimeSeries
+	metadata *prompb.MetricMetadata
+}
+
+func decodeMessage(msg *sarama.ConsumerMessage) (decodedMessage, error) {
+	meta, err := envelope.Parse(msg.Headers)
+	if err != nil {
+		return decodedMessage{}, err
+	}
+
+	if meta.SchemaVersion > envelope.SchemaVersion {
+		return decodedMessage{}, fmt.Errorf("unsupported envelope schema version: %d", meta.SchemaVersion)
+	}
+
+	decoded := decodedMessage{
+		msg:  msg,
+		meta: meta,
+	}
+
+	switch meta.Kind {
+	case envelope.KindMetadata:
+		decoded.metadata = &prompb.MetricMetadata{}
+		if err := proto.Unmarshal(msg.Value, decoded.metadata); err != nil {
+			return decoded, fmt.Errorf("error unmarshaling metadata: %w", err)
+		}
+
+	case envelope.KindTimeSeries:
+		var series prompb.TimeSeries
+		if err := proto.Unmarshal(msg.Value, &series); err != nil {
+			return decoded, fmt.Errorf("error unmarshaling time series: %w", err)
+		}
+
+		decoded.series = []prompb.TimeSeries{series}
+
+	case envelope.KindWriteRequest:
+		var req prompb.WriteRequest
+		if err := proto.Unmarshal(msg.Value, &req); err != nil {
+			return decoded, fmt.Errorf("error unmarshaling write request: %w", err)
+		}
+
+		decoded.series = req.Timeseries
+
+	default:
+		return decoded, fmt.Errorf("unknown message kind: %s", meta.Kind)
+	}
+
+	return decoded, nil
+}
+
+// dispatch decodes messages, grouping them by tenant, and adds the batch to the queues of all destinations.
+// Undecodable messages are sent to the dead-letter topic.
+func (consumer *Consumer) dispatch(ctx context.Context, messages []*sarama.ConsumerMessage) (*batch, error) {
+	batchCtx, cancel := context.WithCancel(context.Background())
+
+	b := &batch{
+		ctx:      batchCtx,
+		cancel:   cancel,
+		messages: make(map[string][]decodedMessage),
+		last:     messages[len(messages)-1],
+		pending:  len(consumer.destinations),
+		done:     make(chan struct{}),
+	}
+
+	var messagesSize int
+
+	for _, msg := range messages {
+		messagesSize += len(msg.Value)
+
+		row, err := decodeMessage(msg)
+		if err != nil {
+			if err := consumer.sendDeadLetter(ctx, msg, "", deadLetterUndecodable, err); err != nil {
+				cancel()
+				return nil, err
+			}
+
+			continue
+		}
+
+		if _, ok := b.messages[row.meta.Tenant]; !ok {
+			b.tenants = append(b.tenants, row.meta.Tenant)
+		}
+
+		b.messages[row.meta.Tenant] = append(b.messages[row.meta.Tenant], row)
+	}
+
+	metricBatchMessages.Observe(float64(len(messages)))
+	metricBatchBytes.Observe(float64(messagesSize))
+
+	if b.pending == 0 {
+		close(b.done)
+	}
+
+	for _, d := range consumer.destinations {
+		d.enqueue(ctx, b)
+	}
+
+	return b, nil
+}
+
+func buildWriteRequest(messages []decodedMessage) ([]byte, error) {
+	timeSeries := &prompb.WriteRequest{}
+
+	metadataIndex := make(map[string]int)
+
+	for _, row := range messages {
+		timeSeries.Timeseries = append(timeSeries.Timeseries, row.series...)
+
+		if row.metadata == nil {
+			continue
+		}
+
+		// keep only the latest metadata of the metric family within the batch
+		if idx, ok := metadataIndex[row.metadata.MetricFamilyName]; ok {
+			timeSeries.Metadata[idx] = *row.metadata
+			continue
+		}
+
+		metadataIndex[row.metadata.MetricFamilyName] = len(timeSeries.Metadata)
+		timeSeries.Metadata = append(timeSeries.Metadata, *row.metadata)
+	}
+
+	messageBytes, err := proto.Marshal(timeSeries)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling protobuf: %w", err)
+	}
+
+	return snappy.Encode(nil, messageBytes), nil
+}
@@ -0,0 +1,334 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
+)
+
+type testSession struct {
+	ctx context.Context
+
+	mu     sync.Mutex
+	marked []int64
+}
+
+func (s *testSession) Claims() map[string][]int32               { return nil }
+func (s *testSession) MemberID() string                         { return "test" }
+func (s *testSession) GenerationID() int32                      { return 1 }
+func (s *testSession) MarkOffset(string, int32, int64, string)  {}
+func (s *testSession) Commit()                                  {}
+func (s *testSession) ResetOffset(string, int32, int64, string) {}
+func (s *testSession) Context() context.Context                 { return s.ctx }
+func (s *testSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *testSession) markedOffsets() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]int64(nil), s.marked...)
+}
+
+type testClaim struct {
+	messages      chan *sarama.ConsumerMessage
+	highWaterMark int64
+}
+
+func (c *testClaim) Topic() string                            { return "metrics" }
+func (c *testClaim) Partition() int32                         { return 0 }
+func (c *testClaim) InitialOffset() int64                     { return 0 }
+func (c *testClaim) HighWaterMarkOffset() int64               { return c.highWaterMark }
+func (c *testClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func newTestMessage(t *testing.T, offset int64, name string) *sarama.ConsumerMessage {
+	t.Helper()
+
+	data, err := proto.Marshal(&prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "__name__", Value: name}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	})
+	require.NoError(t, err)
+
+	return &sarama.ConsumerMessage{Topic: "metrics", Offset: offset, Value: data}
+}
+
+func decodeTestWriteRequest(t *testing.T, r *http.Request) *prompb.WriteRequest {
+	t.Helper()
+
+	compressed, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+
+	data, err := snappy.Decode(nil, compressed)
+	require.NoError(t, err)
+
+	var req prompb.WriteRequest
+	require.NoError(t, proto.Unmarshal(data, &req))
+
+	return &req
+}
+
+func newTestDestination(name, url string) *destination {
+	return &destination{
+		name:       name,
+		remoteURL:  url,
+		httpClient: &http.Client{},
+		backoff:    backoff{minDelay: time.Millisecond, maxDelay: time.Millisecond},
+		queues:     []chan *batch{make(chan *batch, 4)},
+		fullPolicy: queueFullBlock,
+	}
+}
+
+func newTestConsumer(t *testing.T, destinations ...*destination) *Consumer {
+	t.Helper()
+
+	consumer := NewConsumer(destinations...)
+	consumer.batchTime = time.Hour
+	consumer.backoff = backoff{minDelay: time.Millisecond, maxDelay: time.Millisecond}
+	t.Cleanup(consumer.Close)
+
+	return consumer
+}
+
+// consumeTestMessages consumes the messages from a claim closed after them and returns the marked offsets.
+func consumeTestMessages(t *testing.T, consumer *Consumer, messages ...*sarama.ConsumerMessage) ([]int64, error) {
+	t.Helper()
+
+	session := &testSession{ctx: context.Background()}
+	claim := &testClaim{messages: make(chan *sarama.ConsumerMessage, len(messages))}
+
+	for _, msg := range messages {
+		claim.messages <- msg
+	}
+
+	close(claim.messages)
+
+	err := consumer.ConsumeClaim(session, claim)
+
+	return session.markedOffsets(), err
+}
+
+func TestConsumeClaim(t *testing.T) {
+	t.Run("offsets are marked after delivery", func(t *testing.T) {
+		var requests atomic.Int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the first attempt fails and must be retried
+			if requests.Add(1) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			req := decodeTestWriteRequest(t, r)
+			assert.Len(t, req.Timeseries, 2)
+
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		consumer := newTestConsumer(t, newTestDestination("default", server.URL))
+		consumer.batchLen = 2
+
+		marked, err := consumeTestMessages(t, consumer, newTestMessage(t, 10, "first"), newTestMessage(t, 11, "second"))
+		require.NoError(t, err)
+
+		assert.Equal(t, int32(2), requests.Load())
+		assert.Equal(t, []int64{11}, marked)
+	})
+
+	t.Run("pending batch is flushed when the claim is revoked", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := decodeTestWriteRequest(t, r)
+			assert.Len(t, req.Timeseries, 1)
+
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		consumer := newTestConsumer(t, newTestDestination("default", server.URL))
+
+		marked, err := consumeTestMessages(t, consumer, newTestMessage(t, 5, "pending"))
+		require.NoError(t, err)
+		assert.Equal(t, []int64{5}, marked)
+	})
+
+	t.Run("undelivered batch is not marked", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		consumer := newTestConsumer(t, newTestDestination("default", server.URL))
+		consumer.flushTimeout = 50 * time.Millisecond
+
+		marked, err := consumeTestMessages(t, consumer, newTestMessage(t, 1, "lost"))
+		assert.Error(t, err)
+		assert.Empty(t, marked)
+	})
+}
+
+func TestConsumeClaimPayload(t *testing.T) {
+	var received *prompb.WriteRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = decodeTestWriteRequest(t, r)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	metadata := func(help string) []byte {
+		data, err := proto.Marshal(&prompb.MetricMetadata{MetricFamilyName: "up", Help: help})
+		require.NoError(t, err)
+
+		return data
+	}
+
+	metadataHeaders := []*sarama.RecordHeader{{Key: []byte(envelope.HeaderKind), Value: []byte(envelope.KindMetadata)}}
+
+	messages := []*sarama.ConsumerMessage{
+		newTestMessage(t, 1, "up"),
+		{Offset: 2, Value: []byte{0xff, 0xff}},
+		{Offset: 3, Value: metadata("old"), Headers: metadataHeaders},
+		{Offset: 4, Value: metadata("new"), Headers: metadataHeaders},
+	}
+
+	consumer := newTestConsumer(t, newTestDestination("default", server.URL))
+
+	marked, err := consumeTestMessages(t, consumer, messages...)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4}, marked)
+
+	require.NotNil(t, received)
+	assert.Len(t, received.Timeseries, 1)
+	assert.Equal(t, []prompb.MetricMetadata{{MetricFamilyName: "up", Help: "new"}}, received.Metadata)
+}
+
+func TestConsumeClaimTenants(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string][]string)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := decodeTestWriteRequest(t, r)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, ts := range req.Timeseries {
+			key := r.URL.Path + " " + r.Header.Get(tenantHeader)
+			requests[key] = append(requests[key], ts.Labels[0].Value)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d := newTestDestination("default", server.URL+"/insert/{tenant}/prometheus/api/v1/write")
+	d.defaultTenant = "0"
+
+	consumer := newTestConsumer(t, d)
+
+	withTenant := func(msg *sarama.ConsumerMessage, tenant string) *sarama.ConsumerMessage {
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(envelope.HeaderTenant), Value: []byte(tenant)})
+		return msg
+	}
+
+	_, err := consumeTestMessages(t, consumer,
+		withTenant(newTestMessage(t, 1, "a1"), "1:2"),
+		newTestMessage(t, 2, "default"),
+		withTenant(newTestMessage(t, 3, "a2"), "1:2"),
+		withTenant(newTestMessage(t, 4, "b"), "7"),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string][]string{
+		"/insert/1:2/prometheus/api/v1/write 1:2": {"a1", "a2"},
+		"/insert/0/prometheus/api/v1/write ":      {"default"},
+		"/insert/7/prometheus/api/v1/write 7":     {"b"},
+	}, requests)
+}
+
+func TestDecodeMessageEnvelope(t *testing.T) {
+	receivedAt := time.UnixMilli(1700000000000)
+
+	t.Run("envelope is parsed", func(t *testing.T) {
+		msg := newTestMessage(t, 1, "up")
+		for _, header := range (envelope.Envelope{
+			Kind:          envelope.KindTimeSeries,
+			User:          "team-a",
+			Protocol:      "prometheus",
+			ReceivedAt:    receivedAt,
+			SchemaVersion: envelope.SchemaVersion,
+		}).Headers() {
+			msg.Headers = append(msg.Headers, &header)
+		}
+
+		decoded, err := decodeMessage(msg)
+		require.NoError(t, err)
+
+		assert.Equal(t, "team-a", decoded.meta.User)
+		assert.Equal(t, "prometheus", decoded.meta.Protocol)
+		assert.Equal(t, receivedAt, decoded.meta.ReceivedAt)
+		assert.Len(t, decoded.series, 1)
+	})
+
+	t.Run("newer schema version is rejected", func(t *testing.T) {
+		msg := newTestMessage(t, 1, "up")
+		msg.Headers = []*sarama.RecordHeader{
+			{Key: []byte(envelope.HeaderSchemaVersion), Value: []byte(strconv.Itoa(envelope.SchemaVersion + 1))},
+		}
+
+		_, err := decodeMessage(msg)
+		assert.Error(t, err)
+	})
+
+	t.Run("delivered messages are accounted by the envelope", func(t *testing.T) {
+		before := testutil.ToFloat64(metricDeliveredMessages.WithLabelValues("default", "42", "team-b"))
+
+		observeDelivered("default", []decodedMessage{
+			{meta: envelope.Envelope{Tenant: "42", User: "team-b", Protocol: "victoriametrics", ReceivedAt: receivedAt}},
+			{meta: envelope.Envelope{Tenant: "42", User: "team-b"}},
+		})
+
+		assert.Equal(t, before+2, testutil.ToFloat64(metricDeliveredMessages.WithLabelValues("default", "42", "team-b")))
+		assert.Equal(t, 1, testutil.CollectAndCount(metricIngestLag, "prometheus_mimic_worker_ingest_lag_seconds"))
+	})
+}
+
+func TestDecodeMessagePacked(t *testing.T) {
+	data, err := proto.Marshal(&prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}, Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}}},
+			{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}, Samples: []prompb.Sample{{Value: 2, Timestamp: 2000}}},
+		},
+	})
+	require.NoError(t, err)
+
+	decoded, err := decodeMessage(&sarama.ConsumerMessage{
+		Value:   data,
+		Headers: []*sarama.RecordHeader{{Key: []byte(envelope.HeaderKind), Value: []byte(envelope.KindWriteRequest)}},
+	})
+	require.NoError(t, err)
+	require.Len(t, decoded.series, 2)
+
+	assert.Equal(t, 2.0, decoded.series[1].Samples[0].Value)
+}
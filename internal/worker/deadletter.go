@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/IBM/sarama"
+
+	"github.com/vitalvas/prometheus-mimic/internal/envelope"
+)
+
+const (
+	headerDeadLetterReason    = "mimic-dlq-reason"
+	headerDeadLetterError     = "mimic-dlq-error"
+	headerDeadLetterTopic     = "mimic-dlq-topic"
+	headerDeadLetterPartition = "mimic-dlq-partition"
+	headerDeadLetterOffset    = "mimic-dlq-offset"
+	// headerDeadLetterDestination is the name of the destination which rejected the message
+	headerDeadLetterDestination = "mimic-dlq-destination"
+
+	// deadLetterUndecodable is a message which payload cannot be decoded
+	deadLetterUndecodable = "undecodable"
+	// deadLetterRejected is a message permanently rejected by the remote endpoint
+	deadLetterRejected = "rejected"
+	// deadLetterUndeliverable is a message the remote endpoint did not accept within the retry max duration
+	deadLetterUndeliverable = "undeliverable"
+	// deadLetterQueueFull is a message of a batch skipped by the destination with the drop policy
+	deadLetterQueueFull = "queue_full"
+)
+
+// sendDeadLetter publishes the message which cannot be delivered to the dead-letter topic
+// with the failure reason and the rejecting destination, if any, in headers. Without the producer
+// configured, the message is logged and dropped.
+func (consumer *Consumer) sendDeadLetter(ctx context.Context, msg *sarama.ConsumerMessage, destination, reason string, cause error) error {
+	if consumer.deadLetterProducer == nil {
+		metricDroppedMessages.WithLabelValues(reason).Inc()
+
+		log.Printf("dropping %s message %s/%d/%d of user %q tenant %q: %v", reason, msg.Topic, msg.Partition, msg.Offset,
+			envelope.Header(msg.Headers, envelope.HeaderUser), envelope.Tenant(msg.Headers), cause)
+		return nil
+	}
+
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+6)
+	for _, header := range msg.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(headerDeadLetterReason), Value: []byte(reason)},
+		sarama.RecordHeader{Key: []byte(headerDeadLetterError), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte(headerDeadLetterTopic), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{Key: []byte(headerDeadLetterPartition), Value: []byte(strconv.FormatInt(int64(msg.Partition), 10))},
+		sarama.RecordHeader{Key: []byte(headerDeadLetterOffset), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+
+	if destination != "" {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(headerDeadLetterDestination), Value: []byte(destination)})
+	}
+
+	message := &sarama.ProducerMessage{
+		Topic:   consumer.deadLetterTopic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+
+	if msg.Key != nil {
+		message.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	log.Printf("sending %s message %s/%d/%d of user %q tenant %q to dead-letter topic %s: %v", reason, msg.Topic, msg.Partition, msg.Offset,
+		envelope.Header(msg.Headers, envelope.HeaderUser), envelope.Tenant(msg.Headers), consumer.deadLetterTopic, cause)
+
+	err := consumer.backoff.retry(ctx, func() error {
+		_, _, err := consumer.deadLetterProducer.SendMessage(message)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	metricDeadLetterMessages.WithLabelValues(reason).Inc()
+
+	return nil
+}
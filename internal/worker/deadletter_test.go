@@ -0,0 +1,197 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestHeader(headers []sarama.RecordHeader, key string) string {
+	for _, header := range headers {
+		if string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+
+	return ""
+}
+
+func TestDeadLetter(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		delivered []string
+	)
+
+	// the remote endpoint rejects any batch containing the "bad" series
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := decodeTestWriteRequest(t, r)
+
+		for _, ts := range req.Timeseries {
+			if ts.Labels[0].Value == "bad" {
+				http.Error(w, "invalid series", http.StatusBadRequest)
+				return
+			}
+		}
+
+		mu.Lock()
+		for _, ts := range req.Timeseries {
+			delivered = append(delivered, ts.Labels[0].Value)
+		}
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	producer := mocks.NewSyncProducer(t, mocks.NewTestConfig())
+
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "dead-letter", msg.Topic)
+		assert.Equal(t, deadLetterUndecodable, getTestHeader(msg.Headers, headerDeadLetterReason))
+		assert.Equal(t, "2", getTestHeader(msg.Headers, headerDeadLetterOffset))
+		assert.Equal(t, "origin", getTestHeader(msg.Headers, "x-origin"))
+		return nil
+	})
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, deadLetterRejected, getTestHeader(msg.Headers, headerDeadLetterReason))
+		assert.Equal(t, "4", getTestHeader(msg.Headers, headerDeadLetterOffset))
+		assert.Contains(t, getTestHeader(msg.Headers, headerDeadLetterError), "invalid series")
+		assert.Equal(t, "default", getTestHeader(msg.Headers, headerDeadLetterDestination))
+		return nil
+	})
+
+	consumer := newTestConsumer(t, newTestDestination("default", server.URL))
+	consumer.deadLetterTopic = "dead-letter"
+	consumer.deadLetterProducer = producer
+
+	messages := []*sarama.ConsumerMessage{
+		newTestMessage(t, 1, "first"),
+		{
+			Topic:   "metrics",
+			Offset:  2,
+			Value:   []byte{0xff, 0xff},
+			Headers: []*sarama.RecordHeader{{Key: []byte("x-origin"), Value: []byte("origin")}},
+		},
+		newTestMessage(t, 3, "second"),
+		newTestMessage(t, 4, "bad"),
+		newTestMessage(t, 5, "third"),
+	}
+
+	marked, err := consumeTestMessages(t, consumer, messages...)
+	require.NoError(t, err)
+	require.NoError(t, producer.Close())
+
+	assert.Equal(t, []int64{5}, marked)
+	assert.ElementsMatch(t, []string{"first", "second", "third"}, delivered)
+}
+
+func TestDeadLetterUndeliverable(t *testing.T) {
+	var delivered atomic.Int32
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered.Add(int32(len(decodeTestWriteRequest(t, r).Timeseries)))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer healthy.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	producer := mocks.NewSyncProducer(t, mocks.NewTestConfig())
+
+	for range 2 {
+		producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			assert.Equal(t, deadLetterUndeliverable, getTestHeader(msg.Headers, headerDeadLetterReason))
+			assert.Equal(t, "down", getTestHeader(msg.Headers, headerDeadLetterDestination))
+			assert.Contains(t, getTestHeader(msg.Headers, headerDeadLetterError), "503")
+			return nil
+		})
+	}
+
+	downDestination := newTestDestination("down", down.URL)
+	downDestination.backoff.maxDuration = 20 * time.Millisecond
+
+	consumer := newTestConsumer(t, newTestDestination("healthy", healthy.URL), downDestination)
+	consumer.deadLetterTopic = "dead-letter"
+	consumer.deadLetterProducer = producer
+
+	// the batch is completed for the unavailable destination instead of blocking the partition
+	marked, err := consumeTestMessages(t, consumer, newTestMessage(t, 1, "first"), newTestMessage(t, 2, "second"))
+	require.NoError(t, err)
+	require.NoError(t, producer.Close())
+
+	assert.Equal(t, []int64{2}, marked)
+	assert.Equal(t, int32(2), delivered.Load())
+}
+
+func TestDeadLetterOutage(t *testing.T) {
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	d := newTestDestination("outage", server.URL)
+	d.backoff.maxDuration = time.Minute
+	// the destination is failing for longer than the max duration
+	d.failingSince = time.Now().Add(-time.Hour)
+
+	dropped := testutil.ToFloat64(metricDroppedMessages.WithLabelValues(deadLetterUndeliverable))
+
+	consumer := newTestConsumer(t, d)
+
+	// the batch is given up after a single attempt
+	marked, err := consumeTestMessages(t, consumer, newTestMessage(t, 1, "up"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []int64{1}, marked)
+	assert.Equal(t, int32(1), requests.Load())
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricDroppedMessages.WithLabelValues(deadLetterUndeliverable))-dropped)
+}
+
+func TestSendMessagesClassification(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		permanent  bool
+	}{
+		{name: "bad request", statusCode: http.StatusBadRequest, permanent: true},
+		{name: "payload too large", statusCode: http.StatusRequestEntityTooLarge, permanent: true},
+		{name: "unprocessable entity", statusCode: http.StatusUnprocessableEntity, permanent: true},
+		{name: "unauthorized", statusCode: http.StatusUnauthorized, permanent: false},
+		{name: "forbidden", statusCode: http.StatusForbidden, permanent: false},
+		{name: "not found", statusCode: http.StatusNotFound, permanent: false},
+		{name: "too many requests", statusCode: http.StatusTooManyRequests, permanent: false},
+		{name: "server error", statusCode: http.StatusBadGateway, permanent: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer server.Close()
+
+			err := newTestDestination("default", server.URL).sendMessages(context.Background(), "", nil)
+			require.Error(t, err)
+
+			var remoteErr *remoteWriteError
+			assert.Equal(t, tt.permanent, errors.As(err, &remoteErr))
+		})
+	}
+}
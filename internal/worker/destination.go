@@ -0,0 +1,429 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	// queueFullBlock makes the consumer wait for the queue of the destination, the batch is marked
+	// only after it was delivered to the destination
+	queueFullBlock = "block"
+	// queueFullDrop skips the destination for batches which do not fit the queue, their messages are sent
+	// to the dead-letter topic and are lost for the destination without it
+	queueFullDrop = "drop"
+)
+
+// reservedHeaders are set by sendMessages and the HTTP client, they cannot be configured for the destination.
+var reservedHeaders = []string{
+	"Authorization",
+	"Content-Encoding",
+	"Content-Type",
+	"X-Prometheus-Remote-Write-Version",
+	tenantHeader,
+}
+
+// permanentStatusCodes reject the payload of the request, it must not be retried.
+var permanentStatusCodes = []int{
+	http.StatusBadRequest,
+	http.StatusRequestEntityTooLarge,
+	http.StatusUnprocessableEntity,
+}
+
+// destination is a remote write endpoint with its own queue of batches, delivered by separate
+// goroutines, so a slow or unavailable destination does not stall the others until its queue is full.
+// The queue is sharded by partition, every shard is delivered by its own goroutine.
+type destination struct {
+	name      string
+	remoteURL string
+	// defaultTenant replaces the tenant placeholder of remoteURL for messages without a tenant
+	defaultTenant string
+
+	httpClient *http.Client
+	headers    map[string]string
+	backoff    backoff
+
+	queues     []chan *batch
+	fullPolicy string
+
+	// sendDeadLetter handles messages permanently rejected by the destination
+	sendDeadLetter func(ctx context.Context, msg *sarama.ConsumerMessage, destination, reason string, cause error) error
+
+	// downstreamMu guards downstreamErr, the recoverable error of the last request to the destination,
+	// and failingSince, the time of the first request of the current run of recoverable failures
+	downstreamMu  sync.Mutex
+	downstreamErr error
+	failingSince  time.Time
+}
+
+func newDestination(config *RemoteWriteConfig) (*destination, error) {
+	httpClient, err := newRemoteWriteClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make([]chan *batch, config.Queue.Concurrency)
+	for idx := range queues {
+		queues[idx] = make(chan *batch, config.Queue.Capacity)
+	}
+
+	return &destination{
+		name:          config.Name,
+		remoteURL:     config.URL,
+		defaultTenant: config.DefaultTenant,
+		httpClient:    httpClient,
+		headers:       config.Headers,
+		backoff: backoff{
+			minDelay:    config.Retry.MinBackoff,
+			maxDelay:    config.Retry.MaxBackoff,
+			maxDuration: config.Retry.maxDuration(),
+		},
+		queues:     queues,
+		fullPolicy: config.Queue.FullPolicy,
+	}, nil
+}
+
+// enqueue adds the batch to the queue of the destination. With the drop policy the batch is completed
+// for the destination without delivery when the queue is full, its messages are dead-lettered.
+func (d *destination) enqueue(ctx context.Context, b *batch) {
+	queue := d.queueFor(b)
+
+	if d.fullPolicy == queueFullDrop {
+		select {
+		case queue <- b:
+		default:
+			b.finish(d.dropBatch(ctx, b))
+			return
+		}
+	} else {
+		select {
+		case queue <- b:
+		case <-ctx.Done():
+			b.finish(ctx.Err())
+			return
+		}
+	}
+
+	metricDestinationQueueLength.WithLabelValues(d.name).Set(float64(d.queueLength()))
+}
+
+// dropBatch sends messages of the batch skipped by the destination to the dead-letter topic.
+func (d *destination) dropBatch(ctx context.Context, b *batch) error {
+	var count int
+	for _, messages := range b.messages {
+		count += len(messages)
+	}
+
+	log.Printf("queue of destination %s is full, dropping batch of %d messages", d.name, count)
+
+	metricDestinationDroppedBatches.WithLabelValues(d.name).Inc()
+	metricDestinationDroppedMessages.WithLabelValues(d.name).Add(float64(count))
+
+	cause := fmt.Errorf("queue of destination %s is full", d.name)
+
+	for _, tenant := range b.tenants {
+		for _, message := range b.messages[tenant] {
+			if err := d.sendDeadLetter(ctx, message.msg, d.name, deadLetterQueueFull, cause); err != nil {
+				return fmt.Errorf("destination %s: %w", d.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// queueFor returns the shard of the queue for the batch, batches of a partition share the shard.
+func (d *destination) queueFor(b *batch) chan *batch {
+	hash := fnv.New32a()
+	hash.Write([]byte(b.last.Topic))
+
+	return d.queues[(hash.Sum32()+uint32(b.last.Partition))%uint32(len(d.queues))]
+}
+
+// queueLength returns the number of batches waiting in all shards of the queue.
+func (d *destination) queueLength() int {
+	var length int
+	for _, queue := range d.queues {
+		length += len(queue)
+	}
+
+	return length
+}
+
+// start starts a delivery goroutine for every shard of the queue.
+func (d *destination) start() {
+	for _, queue := range d.queues {
+		go d.run(queue)
+	}
+}
+
+// close stops the delivery goroutines once the queued batches are completed.
+func (d *destination) close() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+}
+
+// run delivers batches from the shard of the queue until it is closed.
+func (d *destination) run(queue chan *batch) {
+	for b := range queue {
+		metricDestinationQueueLength.WithLabelValues(d.name).Set(float64(d.queueLength()))
+
+		b.finish(d.deliverBatch(b))
+	}
+}
+
+func (d *destination) deliverBatch(b *batch) error {
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, tenant := range b.tenants {
+		if err := d.deliver(b.ctx, tenant, b.messages[tenant]); err != nil {
+			return fmt.Errorf("destination %s: %w", d.name, err)
+		}
+	}
+
+	return nil
+}
+
+// deliver sends messages to the destination, retrying recoverable failures. When the batch is
+// permanently rejected, it is split in halves to find and dead-letter the rejected messages.
+func (d *destination) deliver(ctx context.Context, tenant string, messages []decodedMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	payload, err := buildWriteRequest(messages)
+	if err != nil {
+		return err
+	}
+
+	var attempts int
+
+	// the max duration counts from the start of the outage, so once it is reached batches are given up
+	// after a single attempt instead of stalling the other destinations
+	err = d.backoff.retryFrom(ctx, d.outageStart(), func() error {
+		if attempts++; attempts > 1 {
+			metricDestinationRetries.WithLabelValues(d.name).Inc()
+		}
+
+		started := time.Now()
+
+		err := d.sendMessages(ctx, tenant, payload)
+		d.observeRequest(err, time.Since(started))
+
+		return err
+	})
+	if err == nil {
+		observeDelivered(d.name, messages)
+		return nil
+	}
+
+	var exhaustedErr *retryExhaustedError
+	if errors.As(err, &exhaustedErr) {
+		log.Printf("giving up on %d messages of destination %s: %v", len(messages), d.name, err)
+
+		metricDestinationAbandonedMessages.WithLabelValues(d.name).Add(float64(len(messages)))
+
+		for _, message := range messages {
+			if err := d.sendDeadLetter(ctx, message.msg, d.name, deadLetterUndeliverable, exhaustedErr.err); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var remoteErr *remoteWriteError
+	if !errors.As(err, &remoteErr) {
+		return err
+	}
+
+	if len(messages) == 1 {
+		return d.sendDeadLetter(ctx, messages[0].msg, d.name, deadLetterRejected, remoteErr)
+	}
+
+	middle := len(messages) / 2
+
+	if err := d.deliver(ctx, tenant, messages[:middle]); err != nil {
+		return err
+	}
+
+	return d.deliver(ctx, tenant, messages[middle:])
+}
+
+// observeRequest records the result of the request to the destination. The destination is reachable
+// when it accepted the request or rejected it permanently.
+func (d *destination) observeRequest(err error, duration time.Duration) {
+	metricDestinationRequestDuration.WithLabelValues(d.name).Observe(duration.Seconds())
+
+	if err == nil {
+		metricDestinationRequests.WithLabelValues(d.name, "success").Inc()
+	} else {
+		metricDestinationRequests.WithLabelValues(d.name, "failure").Inc()
+	}
+
+	var remoteErr *remoteWriteError
+	if errors.As(err, &remoteErr) {
+		err = nil
+	}
+
+	d.downstreamMu.Lock()
+	defer d.downstreamMu.Unlock()
+
+	d.downstreamErr = err
+
+	switch {
+	case err == nil:
+		d.failingSince = time.Time{}
+	case d.failingSince.IsZero():
+		d.failingSince = time.Now().Add(-duration)
+	}
+}
+
+// outageStart returns the time the destination started failing, or the current time when it is not failing.
+func (d *destination) outageStart() time.Time {
+	d.downstreamMu.Lock()
+	defer d.downstreamMu.Unlock()
+
+	if d.failingSince.IsZero() {
+		return time.Now()
+	}
+
+	return d.failingSince
+}
+
+// remoteURLForTenant returns the remote endpoint with the tenant placeholder replaced.
+func (d *destination) remoteURLForTenant(tenant string) string {
+	if tenant == "" {
+		tenant = d.defaultTenant
+	}
+
+	return strings.ReplaceAll(d.remoteURL, tenantPlaceholder, url.PathEscape(tenant))
+}
+
+func (d *destination) sendMessages(ctx context.Context, tenant string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.remoteURLForTenant(tenant), bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+
+	for name, value := range d.headers {
+		req.Header.Set(name, value)
+	}
+
+	if tenant != "" {
+		req.Header.Set(tenantHeader, tenant)
+	}
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	metricDestinationResponses.WithLabelValues(d.name, strconv.Itoa(resp.StatusCode)).Inc()
+
+	if slices.Contains([]int{http.StatusOK, http.StatusNoContent}, resp.StatusCode) {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	// only rejections of the payload are permanent, other statuses such as authentication failures
+	// or a wrong url are fixed on the destination side, so the batch is retried
+	if slices.Contains(permanentStatusCodes, resp.StatusCode) {
+		return &remoteWriteError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	return fmt.Errorf("failed to send batch. Unexpected status code: %d. Body: %s", resp.StatusCode, string(body))
+}
+
+// remoteWriteError is a permanent rejection of the request by the remote endpoint, which must not be retried.
+type remoteWriteError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *remoteWriteError) Error() string {
+	return fmt.Sprintf("remote endpoint rejected batch with status code %d: %s", e.StatusCode, e.Body)
+}
+
+// retryExhaustedError is the last recoverable error of retries given up after the max duration.
+type retryExhaustedError struct {
+	err error
+}
+
+func (e *retryExhaustedError) Error() string {
+	return fmt.Sprintf("giving up after retries: %v", e.err)
+}
+
+func (e *retryExhaustedError) Unwrap() error {
+	return e.err
+}
+
+// backoff is the exponential backoff between retries of recoverable failures.
+type backoff struct {
+	minDelay time.Duration
+	maxDelay time.Duration
+	// maxDuration limits the time of retries, zero retries without limit
+	maxDuration time.Duration
+}
+
+// retry calls fn until it succeeds, returns a permanent error or the context is done.
+func (b backoff) retry(ctx context.Context, fn func() error) error {
+	return b.retryFrom(ctx, time.Now(), fn)
+}
+
+// retryFrom is retry with the max duration counted from started. With the max duration set, it returns
+// retryExhaustedError when the next attempt would start after the duration.
+func (b backoff) retryFrom(ctx context.Context, started time.Time, fn func() error) error {
+	delay := b.minDelay
+
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		var remoteErr *remoteWriteError
+		if errors.As(err, &remoteErr) {
+			return err
+		}
+
+		if b.maxDuration > 0 && time.Since(started)+delay > b.maxDuration {
+			return &retryExhaustedError{err: err}
+		}
+
+		log.Printf("retrying after error: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, b.maxDelay)
+	}
+}
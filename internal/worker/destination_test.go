@@ -0,0 +1,236 @@
+package worker
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	promconfig "github.com/prometheus/common/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFanOut returns a fast destination counting requests and a slow one blocked until release is closed.
+func newTestFanOut(t *testing.T) (fast, slow *destination, fastRequests *atomic.Int32, release chan struct{}) {
+	t.Helper()
+
+	fastRequests = &atomic.Int32{}
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fastRequests.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(fastServer.Close)
+
+	release = make(chan struct{})
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(slowServer.Close)
+
+	return newTestDestination("fast", fastServer.URL), newTestDestination("slow", slowServer.URL), fastRequests, release
+}
+
+func TestFanOut(t *testing.T) {
+	t.Run("slow destination does not stall others", func(t *testing.T) {
+		fast, slow, fastRequests, release := newTestFanOut(t)
+
+		consumer := newTestConsumer(t, fast, slow)
+		consumer.batchLen = 1
+
+		session := &testSession{ctx: context.Background()}
+		claim := &testClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+
+		done := make(chan error)
+		go func() { done <- consumer.ConsumeClaim(session, claim) }()
+
+		for offset := int64(1); offset <= 3; offset++ {
+			claim.messages <- newTestMessage(t, offset, "up")
+		}
+
+		assert.Eventually(t, func() bool { return fastRequests.Load() == 3 }, time.Second, time.Millisecond)
+
+		// offsets wait for the slow destination
+		assert.Empty(t, session.markedOffsets())
+
+		close(release)
+		close(claim.messages)
+
+		require.NoError(t, <-done)
+		assert.Equal(t, []int64{1, 2, 3}, session.markedOffsets())
+	})
+
+	t.Run("full queue drops batches of the destination", func(t *testing.T) {
+		fast, slow, fastRequests, release := newTestFanOut(t)
+		slow.name = "slow-drop"
+		slow.queues = []chan *batch{make(chan *batch, 1)}
+		slow.fullPolicy = queueFullDrop
+
+		producer := mocks.NewSyncProducer(t, mocks.NewTestConfig())
+
+		// the first batch is delivering and the second is queued, the third is dead-lettered
+		producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			assert.Equal(t, deadLetterQueueFull, getTestHeader(msg.Headers, headerDeadLetterReason))
+			assert.Equal(t, "slow-drop", getTestHeader(msg.Headers, headerDeadLetterDestination))
+			assert.Equal(t, "3", getTestHeader(msg.Headers, headerDeadLetterOffset))
+			return nil
+		})
+
+		droppedBatches := testutil.ToFloat64(metricDestinationDroppedBatches.WithLabelValues("slow-drop"))
+		droppedMessages := testutil.ToFloat64(metricDestinationDroppedMessages.WithLabelValues("slow-drop"))
+
+		consumer := newTestConsumer(t, fast, slow)
+		consumer.batchLen = 1
+		consumer.deadLetterTopic = "dead-letter"
+		consumer.deadLetterProducer = producer
+
+		session := &testSession{ctx: context.Background()}
+		claim := &testClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+
+		done := make(chan error)
+		go func() { done <- consumer.ConsumeClaim(session, claim) }()
+
+		// the first batch must be taken from the queue before the others are consumed
+		claim.messages <- newTestMessage(t, 1, "up")
+		assert.Eventually(t, func() bool { return len(slow.queues[0]) == 0 }, time.Second, time.Millisecond)
+
+		claim.messages <- newTestMessage(t, 2, "up")
+		claim.messages <- newTestMessage(t, 3, "up")
+
+		assert.Eventually(t, func() bool { return fastRequests.Load() == 3 }, time.Second, time.Millisecond)
+		assert.Equal(t, 1.0, testutil.ToFloat64(metricDestinationDroppedBatches.WithLabelValues("slow-drop"))-droppedBatches)
+		assert.Equal(t, 1.0, testutil.ToFloat64(metricDestinationDroppedMessages.WithLabelValues("slow-drop"))-droppedMessages)
+
+		close(release)
+		close(claim.messages)
+
+		require.NoError(t, <-done)
+		require.NoError(t, producer.Close())
+		assert.Equal(t, []int64{1, 2, 3}, session.markedOffsets())
+	})
+
+	t.Run("failed destination fails the batch", func(t *testing.T) {
+		fast, slow, _, release := newTestFanOut(t)
+		defer close(release)
+
+		consumer := newTestConsumer(t, fast, slow)
+		consumer.flushTimeout = 50 * time.Millisecond
+
+		marked, err := consumeTestMessages(t, consumer, newTestMessage(t, 1, "up"))
+		assert.Error(t, err)
+		assert.Empty(t, marked)
+	})
+}
+
+func TestDestinationConcurrency(t *testing.T) {
+	var (
+		active  atomic.Int32
+		once    sync.Once
+		reached = make(chan struct{})
+	)
+
+	// requests wait until both batches are sent at the same time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if active.Add(1) == 2 {
+			once.Do(func() { close(reached) })
+		}
+		defer active.Add(-1)
+
+		select {
+		case <-reached:
+			w.WriteHeader(http.StatusNoContent)
+		case <-time.After(time.Second):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	d := newTestDestination("concurrent", server.URL)
+	d.queues = []chan *batch{make(chan *batch, 1), make(chan *batch, 1)}
+
+	consumer := newTestConsumer(t, d)
+
+	var batches []*batch
+
+	for partition := range int32(2) {
+		msg := newTestMessage(t, 1, "up")
+		msg.Partition = partition
+
+		b, err := consumer.dispatch(context.Background(), []*sarama.ConsumerMessage{msg})
+		require.NoError(t, err)
+		defer b.cancel()
+
+		batches = append(batches, b)
+	}
+
+	// batches of different partitions are in different shards
+	assert.NotEqual(t, d.queueFor(batches[0]), d.queueFor(batches[1]))
+
+	for _, b := range batches {
+		select {
+		case <-b.done:
+			require.NoError(t, b.result())
+		case <-time.After(5 * time.Second):
+			t.Fatal("batches are not delivered concurrently")
+		}
+	}
+}
+
+func TestDestinationAuth(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers []http.Header
+	)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("first\n"), 0o600))
+
+	config := &RemoteWriteConfig{
+		Name:            "secure",
+		URL:             server.URL,
+		BearerTokenFile: tokenFile,
+		TLSConfig:       promconfig.TLSConfig{CAFile: caFile},
+		Headers:         map[string]string{"X-Source": "mimic"},
+	}
+	config.setDefaults(RetryConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	require.NoError(t, config.validate())
+
+	d, err := newDestination(config)
+	require.NoError(t, err)
+
+	require.NoError(t, d.sendMessages(context.Background(), "", nil))
+
+	// the token file is read on every request
+	require.NoError(t, os.WriteFile(tokenFile, []byte("second\n"), 0o600))
+	require.NoError(t, d.sendMessages(context.Background(), "", nil))
+
+	require.Len(t, headers, 2)
+	assert.Equal(t, "Bearer first", headers[0].Get("Authorization"))
+	assert.Equal(t, "Bearer second", headers[1].Get("Authorization"))
+	assert.Equal(t, "mimic", headers[1].Get("X-Source"))
+	assert.Equal(t, "snappy", headers[1].Get("Content-Encoding"))
+}
@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// readiness checks that the consumer is a member of the group and the last request to every destination
+// did not fail with a recoverable error.
+func (consumer *Consumer) readiness() error {
+	if !consumer.member.Load() {
+		return errors.New("consumer is not a member of the consumer group")
+	}
+
+	for _, d := range consumer.destinations {
+		d.downstreamMu.Lock()
+		err := d.downstreamErr
+		d.downstreamMu.Unlock()
+
+		if err != nil {
+			return fmt.Errorf("remote endpoint of destination %s is not reachable: %w", d.name, err)
+		}
+	}
+
+	return nil
+}
+
+func healthyHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "prometheus-mimic-worker is healthy")
+}
+
+func (consumer *Consumer) readyHandler(w http.ResponseWriter, _ *http.Request) {
+	if err := consumer.readiness(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "prometheus-mimic-worker is not ready: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "prometheus-mimic-worker is ready")
+}
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadyHandler(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	consumer := newTestConsumer(t, newTestDestination("default", server.URL))
+	consumer.flushTimeout = 50 * time.Millisecond
+
+	serveReady := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		consumer.readyHandler(w, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+
+		return w
+	}
+
+	w := serveReady()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "not a member")
+
+	require.NoError(t, consumer.Setup(nil))
+	assert.Equal(t, http.StatusOK, serveReady().Code)
+
+	_, err := consumeTestMessages(t, consumer, newTestMessage(t, 1, "up"))
+	assert.Error(t, err)
+
+	w = serveReady()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "remote endpoint of destination default is not reachable")
+
+	// a permanent rejection means the endpoint is reachable
+	status.Store(http.StatusBadRequest)
+	_, err = consumeTestMessages(t, consumer, newTestMessage(t, 2, "up"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serveReady().Code)
+
+	// wrong credentials are retried and make the endpoint not reachable
+	status.Store(http.StatusUnauthorized)
+
+	_, err = consumeTestMessages(t, consumer, newTestMessage(t, 3, "up"))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, serveReady().Code)
+
+	require.NoError(t, consumer.Cleanup(nil))
+	assert.Equal(t, http.StatusServiceUnavailable, serveReady().Code)
+}
+
+func TestHealthyHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	healthyHandler(w, httptest.NewRequest(http.MethodGet, "/-/healthy", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
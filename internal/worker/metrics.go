@@ -0,0 +1,206 @@
+package worker
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricNamespace = "prometheus_mimic"
+	metricSubsystem = "worker"
+)
+
+var (
+	metricConsumedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "consumed_messages_total",
+		},
+		[]string{"topic"},
+	)
+	metricConsumedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "consumed_bytes_total",
+		},
+		[]string{"topic"},
+	)
+	metricConsumerLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "consumer_lag_messages",
+			Help:      "Messages between the last consumed offset and the high-water mark of the claimed partition",
+		},
+		[]string{"topic", "partition"},
+	)
+	metricBatchMessages = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "batch_messages",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+		},
+	)
+	metricBatchBytes = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "batch_bytes",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+		},
+	)
+	metricDeadLetterMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "dead_letter_messages_total",
+		},
+		[]string{"reason"},
+	)
+	metricDroppedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "dropped_messages_total",
+			Help:      "Messages which cannot be delivered, dropped without the dead-letter topic",
+		},
+		[]string{"reason"},
+	)
+	metricIngestLag = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "ingest_lag_seconds",
+			Help:      "Time from receiving the message by the gateway to its delivery to the destination",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+		},
+		[]string{"destination", "protocol"},
+	)
+	metricDeliveredMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "delivered_messages_total",
+			Help:      "Messages delivered to the destination, by the tenant and the user who sent them",
+		},
+		[]string{"destination", "tenant", "user"},
+	)
+	metricDestinationRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "destination_requests_total",
+		},
+		[]string{"destination", "result"},
+	)
+	metricDestinationResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "destination_responses_total",
+		},
+		[]string{"destination", "code"},
+	)
+	metricDestinationRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "destination_retries_total",
+		},
+		[]string{"destination"},
+	)
+	metricDestinationRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "destination_request_duration_seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		},
+		[]string{"destination"},
+	)
+	metricDestinationQueueLength = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "destination_queue_length",
+			Help:      "Batches waiting in the queue of the destination",
+		},
+		[]string{"destination"},
+	)
+	metricDestinationDroppedBatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "destination_dropped_batches_total",
+			Help:      "Batches skipped for the destination because its queue was full",
+		},
+		[]string{"destination"},
+	)
+	metricDestinationDroppedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "destination_dropped_messages_total",
+			Help:      "Messages of batches skipped for the destination because its queue was full",
+		},
+		[]string{"destination"},
+	)
+	metricDestinationAbandonedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: metricSubsystem,
+			Name:      "destination_abandoned_messages_total",
+			Help:      "Messages not delivered to the destination within the retry max duration",
+		},
+		[]string{"destination"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricConsumedMessages)
+	prometheus.MustRegister(metricConsumedBytes)
+	prometheus.MustRegister(metricConsumerLag)
+	prometheus.MustRegister(metricBatchMessages)
+	prometheus.MustRegister(metricBatchBytes)
+	prometheus.MustRegister(metricDeadLetterMessages)
+	prometheus.MustRegister(metricDroppedMessages)
+	prometheus.MustRegister(metricIngestLag)
+	prometheus.MustRegister(metricDeliveredMessages)
+	prometheus.MustRegister(metricDestinationRequests)
+	prometheus.MustRegister(metricDestinationResponses)
+	prometheus.MustRegister(metricDestinationRetries)
+	prometheus.MustRegister(metricDestinationRequestDuration)
+	prometheus.MustRegister(metricDestinationQueueLength)
+	prometheus.MustRegister(metricDestinationDroppedBatches)
+	prometheus.MustRegister(metricDestinationDroppedMessages)
+	prometheus.MustRegister(metricDestinationAbandonedMessages)
+}
+
+// observeDelivered accounts the messages delivered to the destination by their envelope.
+func observeDelivered(destination string, messages []decodedMessage) {
+	now := time.Now()
+
+	for _, row := range messages {
+		metricDeliveredMessages.WithLabelValues(destination, row.meta.Tenant, row.meta.User).Inc()
+
+		if !row.meta.ReceivedAt.IsZero() {
+			metricIngestLag.WithLabelValues(destination, row.meta.Protocol).Observe(now.Sub(row.meta.ReceivedAt).Seconds())
+		}
+	}
+}
+
+// observeConsumed accounts the consumed message and the lag of its partition.
+func observeConsumed(claim sarama.ConsumerGroupClaim, msg *sarama.ConsumerMessage) {
+	metricConsumedMessages.WithLabelValues(msg.Topic).Inc()
+	metricConsumedBytes.WithLabelValues(msg.Topic).Add(float64(len(msg.Value)))
+
+	// the high-water mark is the offset of the next message to be produced
+	lag := max(claim.HighWaterMarkOffset()-msg.Offset-1, 0)
+	metricConsumerLag.WithLabelValues(claim.Topic(), strconv.FormatInt(int64(claim.Partition()), 10)).Set(float64(lag))
+}
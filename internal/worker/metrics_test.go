@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumerMetrics(t *testing.T) {
+	var requests atomic.Int32
+
+	// the first request is throttled and retried
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	consumedBefore := testutil.ToFloat64(metricConsumedMessages.WithLabelValues("metrics"))
+	droppedBefore := testutil.ToFloat64(metricDroppedMessages.WithLabelValues(deadLetterUndecodable))
+	throttledBefore := testutil.ToFloat64(metricDestinationResponses.WithLabelValues("metrics-test", "429"))
+	acceptedBefore := testutil.ToFloat64(metricDestinationResponses.WithLabelValues("metrics-test", "204"))
+	retriesBefore := testutil.ToFloat64(metricDestinationRetries.WithLabelValues("metrics-test"))
+
+	consumer := newTestConsumer(t, newTestDestination("metrics-test", server.URL))
+	consumer.batchLen = 3
+
+	session := &testSession{ctx: context.Background()}
+	claim := &testClaim{messages: make(chan *sarama.ConsumerMessage, 3), highWaterMark: 10}
+
+	done := make(chan error)
+	go func() { done <- consumer.ConsumeClaim(session, claim) }()
+
+	claim.messages <- newTestMessage(t, 5, "first")
+	claim.messages <- newTestMessage(t, 6, "second")
+	claim.messages <- &sarama.ConsumerMessage{Topic: "metrics", Offset: 7, Value: []byte{0xff, 0xff}}
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metricConsumerLag.WithLabelValues("metrics", "0")) == 2
+	}, time.Second, time.Millisecond)
+
+	close(claim.messages)
+
+	require.NoError(t, <-done)
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(metricConsumedMessages.WithLabelValues("metrics"))-consumedBefore)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricDroppedMessages.WithLabelValues(deadLetterUndecodable))-droppedBefore)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricDestinationResponses.WithLabelValues("metrics-test", "429"))-throttledBefore)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricDestinationResponses.WithLabelValues("metrics-test", "204"))-acceptedBefore)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricDestinationRetries.WithLabelValues("metrics-test"))-retriesBefore)
+
+	// the partition series is removed with the claim
+	assert.Zero(t, testutil.CollectAndCount(metricConsumerLag))
+}